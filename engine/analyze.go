@@ -0,0 +1,109 @@
+// analyze.go implements a streaming interface to the search
+// for programs embedding the engine.
+
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+// Limits restricts the search started by Analyze.
+// The zero value searches until the context is cancelled.
+type Limits struct {
+	Depth    int32         // maximum depth searched, 0 for no limit
+	MoveTime time.Duration // maximum time searched, 0 for no limit
+}
+
+// AnalysisUpdate reports the search progress after each completed depth.
+type AnalysisUpdate struct {
+	Depth    int32         // depth searched
+	SelDepth int32         // maximum depth reached on PV
+	Score    int32         // score in centipawns from the side to move's POV
+	Mate     int32         // moves to mate, negative if mated, 0 if no mate was found
+	Nodes    uint64        // number of nodes searched
+	Elapsed  time.Duration // time since the search started
+	PV       []Move        // principal variation, empty if the game has ended
+	Final    bool          // true for the last update holding the search result
+}
+
+// analyzeLogger sends an update for every principal variation
+// and passes everything to the engine's logger.
+type analyzeLogger struct {
+	Logger
+	updates chan<- AnalysisUpdate
+	start   time.Time
+	last    AnalysisUpdate
+}
+
+func (al *analyzeLogger) PrintPV(stats Stats, score int32, pv []Move) {
+	al.Logger.PrintPV(stats, score, pv)
+
+	al.last = AnalysisUpdate{
+		Depth:    stats.Depth,
+		SelDepth: stats.SelDepth,
+		Score:    score,
+		Nodes:    stats.Nodes,
+		Elapsed:  time.Since(al.start),
+		PV:       append([]Move(nil), pv...),
+	}
+	if score > KnownWinScore {
+		al.last.Mate = (MateScore - score + 1) / 2
+	} else if score < KnownLossScore {
+		al.last.Mate = (MatedScore - score) / 2
+	}
+	al.updates <- al.last
+}
+
+// Analyze searches the current position in the background
+// and streams the progress on the returned channel.
+//
+// The search stops when the limits are reached or when ctx is done.
+// The last update has Final set and holds the search result,
+// after which the channel is closed. The updates are buffered
+// so a slow reader never delays the search.
+//
+// The engine must not be used until the channel is closed.
+func (eng *Engine) Analyze(ctx context.Context, limits Limits) <-chan AnalysisUpdate {
+	tc := NewTimeControl(eng.Position, false)
+	tc.MovesToGo = 1
+	if limits.Depth > 0 {
+		tc.Depth = limits.Depth
+	}
+	if limits.MoveTime > 0 {
+		tc.WTime = limits.MoveTime
+		tc.BTime = limits.MoveTime
+	}
+
+	// One update for each depth and one for the result.
+	updates := make(chan AnalysisUpdate, 64+1)
+	log := eng.Log
+	al := &analyzeLogger{Logger: log, updates: updates, start: time.Now()}
+	eng.Log = al
+	tc.Start(false)
+
+	go func() {
+		defer close(updates)
+
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				tc.Stop()
+			case <-done:
+			}
+		}()
+
+		moves := eng.Play(tc)
+		close(done)
+		eng.Log = log
+
+		final := al.last
+		final.Nodes = eng.Stats.Nodes
+		final.Elapsed = time.Since(al.start)
+		final.PV = moves
+		final.Final = true
+		updates <- final
+	}()
+	return updates
+}
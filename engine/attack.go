@@ -22,6 +22,10 @@ var (
 	bbKingAttack [64]Bitboard
 	// bbSuperAttack contains queen piece's attack tables. This queen can jump.
 	bbSuperAttack [64]Bitboard
+	// bbBetween contains the squares strictly between two aligned squares.
+	bbBetween [64][64]Bitboard
+	// bbLine contains the full line through two aligned squares.
+	bbLine [64][64]Bitboard
 
 	rookMagic    [64]magicInfo
 	rookDeltas   = [][2]int{{-1, +0}, {+1, +0}, {+0, -1}, {+0, +1}}
@@ -34,6 +38,7 @@ func init() {
 	initBbKnightAttack()
 	initBbKingAttack()
 	initBbSuperAttack()
+	initBbBetweenAndLine()
 	initRookMagic()
 	initBishopMagic()
 }
@@ -83,6 +88,23 @@ func initBbSuperAttack() {
 	}
 }
 
+func initBbBetweenAndLine() {
+	for a := SquareMinValue; a <= SquareMaxValue; a++ {
+		for b := SquareMinValue; b <= SquareMaxValue; b++ {
+			if a == b {
+				continue
+			}
+			for _, deltas := range [][][2]int{rookDeltas, bishopDeltas} {
+				if slidingAttack(a, deltas, BbEmpty)&b.Bitboard() == 0 {
+					continue
+				}
+				bbBetween[a][b] = slidingAttack(a, deltas, b.Bitboard()) & slidingAttack(b, deltas, a.Bitboard())
+				bbLine[a][b] = slidingAttack(a, deltas, BbEmpty)&slidingAttack(b, deltas, BbEmpty) | a.Bitboard() | b.Bitboard()
+			}
+		}
+	}
+}
+
 func slidingAttack(sq Square, deltas [][2]int, occupancy Bitboard) Bitboard {
 	r, f := sq.Rank(), sq.File()
 	bb := Bitboard(0)
@@ -558,8 +558,14 @@ func (m Move) IsQuiet() bool {
 // UCI converts a move to UCI format.
 // The protocol specification at http://wbec-ridderkerk.nl/html/UCIProtocol.html
 // incorrectly states that this is the long algebraic notation (LAN).
+// Castling is written as the king moving two squares, or, in Chess960,
+// as the king capturing its own rook.
 func (m Move) UCI() string {
-	return m.From().String() + m.To().String() + figureToSymbol[m.Promotion().Figure()]
+	to := m.To()
+	if m.MoveType() == Castling && !Chess960 {
+		to, _ = castlingSquares(m)
+	}
+	return m.From().String() + to.String() + figureToSymbol[m.Promotion().Figure()]
 }
 
 // LAN converts a move to Long Algebraic Notation.
@@ -572,7 +578,11 @@ func (m Move) LAN() string {
 	} else {
 		r += "-"
 	}
-	r += m.To().String() + figureToSymbol[m.Promotion().Figure()]
+	to := m.To()
+	if m.MoveType() == Castling {
+		to, _ = castlingSquares(m)
+	}
+	r += to.String() + figureToSymbol[m.Promotion().Figure()]
 	return r
 }
 
@@ -610,6 +620,7 @@ func (c Castle) String() string {
 
 // CastlingRook returns the rook moved during castling
 // together with starting and stopping squares.
+// The starting square is the one from standard chess.
 func CastlingRook(kingEnd Square) (Piece, Square, Square) {
 	// Explanation how rookStart works for king on E1.
 	// if kingEnd == C1 == b010, then rookStart == A1 == b000
@@ -625,3 +636,15 @@ func CastlingRook(kingEnd Square) (Piece, Square, Square) {
 	rookEnd := kingEnd ^ (kingEnd & 4 >> 1) | 1
 	return piece, rookStart, rookEnd
 }
+
+// castlingSquares returns the squares where the king and the rook end
+// after the castling move m. Castling is encoded as the king capturing
+// its own rook because in Chess960 the rook can start on any file.
+func castlingSquares(m Move) (kingEnd, rookEnd Square) {
+	kingEnd = RankFile(m.From().Rank(), 2)
+	if m.To().File() > m.From().File() {
+		kingEnd = RankFile(m.From().Rank(), 6)
+	}
+	_, _, rookEnd = CastlingRook(kingEnd)
+	return kingEnd, rookEnd
+}
@@ -0,0 +1,49 @@
+// clock.go implements the clocks used by the time control.
+
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// wallClock is the system clock.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// WallClock is the default clock of TimeControl.
+var WallClock Clock = wallClock{}
+
+// FakeClock is a clock whose time changes only when advanced.
+// It is useful to test time management without waiting.
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock returns a FakeClock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (fc *FakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+// Advance moves the clock forward by d.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	fc.now = fc.now.Add(d)
+	fc.lock.Unlock()
+}
@@ -2,11 +2,17 @@
 // NEW
 package engine
 
-const TEST                   = true
+// Compare the incremental Racing Kings evaluation against
+// the evaluation computed from scratch on every call.
+const DEBUG_INCREMENTAL_EVAL = false
 
 //var Variant int              = VARIANT_Standard
 var Variant int              = VARIANT_Racing_Kings
 
+// Chess960 selects the UCI notation of castling moves: the king
+// captures its own rook instead of moving two squares.
+var Chess960 = false
+
 var START_FENS = [...]string{
 		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
 		"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1",
@@ -7,42 +7,14 @@ import (
 	"strconv"
 )
 
-type castleInfo struct {
-	Castle Castle
-	Piece  [2]Piece
-	Square [2]Square
-}
-
 var (
-	itoa               = "0123456789" // shortcut for Itoa
-	colorToSymbol      = "?bw"
-	pieceToSymbol      = ".?pPnNbBrRqQkK"
+	itoa          = "0123456789" // shortcut for Itoa
+	colorToSymbol = "?bw"
+	pieceToSymbol = ".?pPnNbBrRqQkK"
 	///////////////////////////////////////////////////
 	// NEW
-	pieceToSymbolU     = []rune(".?♙♟♘♞♗♝♖♜♕♛♔♚")
+	pieceToSymbolU = []rune(".?♙♟♘♞♗♝♖♜♕♛♔♚")
 	///////////////////////////////////////////////////
-	symbolToCastleInfo = map[rune]castleInfo{
-		'K': castleInfo{
-			Castle: WhiteOO,
-			Piece:  [2]Piece{WhiteKing, WhiteRook},
-			Square: [2]Square{SquareE1, SquareH1},
-		},
-		'k': castleInfo{
-			Castle: BlackOO,
-			Piece:  [2]Piece{BlackKing, BlackRook},
-			Square: [2]Square{SquareE8, SquareH8},
-		},
-		'Q': castleInfo{
-			Castle: WhiteOOO,
-			Piece:  [2]Piece{WhiteKing, WhiteRook},
-			Square: [2]Square{SquareE1, SquareA1},
-		},
-		'q': castleInfo{
-			Castle: BlackOOO,
-			Piece:  [2]Piece{BlackKing, BlackRook},
-			Square: [2]Square{SquareE8, SquareA8},
-		},
-	}
 	symbolToColor = map[string]Color{
 		"w": White,
 		"b": Black,
@@ -242,7 +214,11 @@ func FormatSideToMove(pos *Position) string {
 }
 
 // ParseCastlingAbility sets castling ability for pos from str.
+// For Chess960 str can be in Shredder-FEN format where the files of the
+// castling rooks are given instead of KQkq (e.g. HFhf), or in X-FEN format
+// where KQkq refer to the outermost rooks.
 func ParseCastlingAbility(str string, pos *Position) error {
+	pos.castling = standardCastling
 	if str == "-" {
 		pos.SetCastlingAbility(NoCastle)
 		return nil
@@ -250,24 +226,82 @@ func ParseCastlingAbility(str string, pos *Position) error {
 
 	ability := NoCastle
 	for _, p := range str {
-		info, ok := symbolToCastleInfo[p]
-		if !ok {
-			return fmt.Errorf("invalid castling ability %s", str)
+		col := White
+		if 'a' <= p && p <= 'z' {
+			col, p = Black, p-'a'+'A'
+		}
+		rank := col.KingHomeRank()
+		king := pos.ByPiece(col, King)
+		if king == 0 || king.AsSquare().Rank() != rank {
+			return fmt.Errorf("invalid castling ability %s: no %v king on the home rank", str, col)
 		}
-		ability |= info.Castle
-		for i := 0; i < 2; i++ {
-			if info.Piece[i] != pos.Get(info.Square[i]) {
-				return fmt.Errorf("expected %v at %v, got %v",
-					info.Piece[i], info.Square[i], pos.Get(info.Square[i]))
+		kingFile := king.AsSquare().File()
+
+		// Find the castling rook. By default the outermost rook.
+		rook := ColorFigure(col, Rook)
+		oo, ooo := castlingRights(col)
+		var castle Castle
+		var sq Square
+		switch {
+		case p == 'K':
+			castle, sq = oo, RankFile(rank, 7)
+			for f := 7; f > kingFile && pos.Get(sq) != rook; f-- {
+				sq = RankFile(rank, f)
+			}
+		case p == 'Q':
+			castle, sq = ooo, RankFile(rank, 0)
+			for f := 0; f < kingFile && pos.Get(sq) != rook; f++ {
+				sq = RankFile(rank, f)
 			}
+		case 'A' <= p && p <= 'H' && int(p-'A') > kingFile:
+			castle, sq = oo, RankFile(rank, int(p-'A'))
+		case 'A' <= p && p <= 'H' && int(p-'A') < kingFile:
+			castle, sq = ooo, RankFile(rank, int(p-'A'))
+		default:
+			return fmt.Errorf("invalid castling ability %s", str)
+		}
+		if pos.Get(sq) != rook {
+			return fmt.Errorf("expected %v at %v, got %v", rook, sq, pos.Get(sq))
 		}
+
+		ability |= castle
+		pos.castling.king[col] = king.AsSquare()
+		pos.castling.rook[castle] = sq
 	}
+	pos.castling.update()
 	pos.SetCastlingAbility(ability)
 	return nil
 }
 
 // FormatCastlingAbility returns a string specifying the castling ability
-// using standard FEN format.
+// using standard FEN format. For Chess960 positions the X-FEN format is
+// used: the file of the castling rook replaces KQkq if another rook stands
+// between it and the corner.
 func FormatCastlingAbility(pos *Position) string {
-	return pos.CastlingAbility().String()
+	ability := pos.CastlingAbility()
+	if ability == NoCastle {
+		return "-"
+	}
+
+	str := ""
+	for _, castle := range [...]Castle{WhiteOO, WhiteOOO, BlackOO, BlackOOO} {
+		if ability&castle == 0 {
+			continue
+		}
+		m := pos.castlingMove(castle)
+		rook, col := m.To(), m.SideToMove()
+		corner := RankFile(rook.Rank(), 0)
+		if castle&(WhiteOO|BlackOO) != 0 {
+			corner = RankFile(rook.Rank(), 7)
+		}
+		outer := (bbBetween[rook][corner] | corner.Bitboard()) &^ rook.Bitboard()
+		if outer&pos.ByPiece(col, Rook) == 0 {
+			str += castle.String()
+		} else if col == White {
+			str += string(rune('A' + rook.File()))
+		} else {
+			str += string(rune('a' + rook.File()))
+		}
+	}
+	return str
 }
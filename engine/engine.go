@@ -7,6 +7,7 @@
 //
 //   * Bitboards for representation - https://chessprogramming.wikispaces.com/Bitboards
 //   * Magic bitboards for sliding move generation - https://chessprogramming.wikispaces.com/Magic+Bitboards
+//   * Legal move generation using pin and check evasion masks (legal.go)
 //
 // Search (engine.go) features implemented are:
 //
@@ -30,6 +31,9 @@
 //   * Hash move heuristic
 //   * Captures sorted by MVVLVA - https://chessprogramming.wikispaces.com/MVV-LVA
 //   * Killer moves - https://chessprogramming.wikispaces.com/Killer+Move
+//   * Counter moves - https://chessprogramming.wikispaces.com/Countermove+Heuristic
+//   * Continuation history of the moves played one and two plies before
+//   * Losing captures (SEE < 0) deferred after the quiet moves
 //
 // Evaluation (material.go) function is quite basic and consists of:
 //
@@ -68,6 +72,7 @@ const (
 	initialAspirationWindow = 21  // ~a quarter of a pawn
 	futilityMargin          = 150 // ~one and a halfpawn
 	checkpointStep          = 10000
+	raceQuiescenceDepth     = 4 // quiet race moves searched in the Racing Kings quiescence
 )
 
 var (
@@ -78,7 +83,9 @@ var (
 
 // Options keeps engine's options.
 type Options struct {
-	AnalyseMode bool // true to display info strings
+	AnalyseMode  bool       // true to display info strings
+	TriangularPV bool       // true to report the principal variation from a triangular array
+	HashTable    *HashTable // transposition table, GlobalHashTable if nil
 }
 
 // Stats stores some basic stats of the search.
@@ -188,6 +195,9 @@ type Engine struct {
 	pvTable pvTable      // principal variation table
 	history historyTable // keeps history of moves
 
+	triangularPV *triangularPV // principal variation if Options.TriangularPV is set
+	evalCache    *cache        // pawns and shelter cache, not shared with other engines
+
 	timeControl *TimeControl
 	stopped     bool
 	checkpoint  uint64
@@ -204,6 +214,9 @@ func NewEngine(pos *Position, log Logger, options Options) *Engine {
 		Log:     log,
 		pvTable: newPvTable(),
 		history: newHistoryTable(),
+
+		triangularPV: &triangularPV{},
+		evalCache:    newPawnsAndShelterCache(),
 	}
 	eng.SetPosition(pos)
 	return eng
@@ -232,10 +245,6 @@ func PrintPieceValues() {
 	}
 }
 
-func IsTest() bool {
-	return TEST
-}
-
 func SetUseUnicodeSymbols(useUnicode bool) {
 	USE_UNICODE_SYMBOLS=useUnicode
 }
@@ -280,7 +289,7 @@ func (eng *Engine) UndoMove() {
 
 // Score evaluates current position from current player's POV.
 func (eng *Engine) Score() int32 {
-	score := Evaluate(eng.Position)
+	score := evaluate(eng.Position, eng.evalCache)
 	score = ScaleToCentiPawn(score)
 	return scoreMultiplier[eng.Position.SideToMove] * score
 }
@@ -288,37 +297,45 @@ func (eng *Engine) Score() int32 {
 // endPosition determines whether the current position is an end game.
 // Returns score and a bool if the game has ended.
 func (eng *Engine) endPosition() (int32, bool) {
-	pos := eng.Position // shortcut
-	// Trivial cases when kings are missing.
-	if pos.ByPiece(White, King) == 0 && pos.ByPiece(Black, King) == 0 {
-		return 0, true
-	}
-	if pos.ByPiece(White, King) == 0 {
-		return scoreMultiplier[pos.SideToMove] * (MatedScore + eng.ply()), true
-	}
-	if pos.ByPiece(Black, King) == 0 {
-		return scoreMultiplier[pos.SideToMove] * (MateScore - eng.ply()), true
-	}
-	// Neither side cannot mate.
-	if pos.InsufficientMaterial() {
-		return 0, true
-	}
-	// Fifty full moves without a capture or a pawn move.
-	if pos.FiftyMoveRule() {
-		return 0, true
-	}
 	// Repetition is a draw.
 	// At root we need to continue searching even if we saw two repetitions already,
 	// however we can prune deeper search only at two repetitions.
-	if r := pos.ThreeFoldRepetition(); eng.ply() > 0 && r >= 2 || r >= 3 {
+	repetitions := 3
+	if eng.ply() > 0 {
+		repetitions = 2
+	}
+
+	pos := eng.Position // shortcut
+	switch r := pos.staticResult(repetitions); r.Outcome {
+	case Ongoing:
+		return 0, false
+	case Draw:
 		return 0, true
+	case WhiteWins:
+		return scoreMultiplier[pos.SideToMove] * (MateScore - eng.ply()), true
+	default: // BlackWins
+		return scoreMultiplier[pos.SideToMove] * (MatedScore + eng.ply()), true
+	}
+}
+
+// hashTable returns the transposition table used by the engine.
+//
+// Engines searching concurrently must have their own tables
+// because HashTable is not safe for concurrent use.
+func (eng *Engine) hashTable() *HashTable {
+	if eng.Options.HashTable != nil {
+		return eng.Options.HashTable
 	}
-	return 0, false
+	return GlobalHashTable
 }
 
-// retrieveHash gets from GlobalHashTable the current position.
+// retrieveHash gets from the transposition table the current position.
 func (eng *Engine) retrieveHash() hashEntry {
-	entry := GlobalHashTable.get(eng.Position)
+	if eng.isRestricted() {
+		// The entry may be for a move not in SearchMoves.
+		return hashEntry{}
+	}
+	entry := eng.hashTable().get(eng.Position)
 
 	if entry.kind == noEntry {
 		eng.Stats.CacheMiss++
@@ -346,8 +363,12 @@ func (eng *Engine) retrieveHash() hashEntry {
 	return entry
 }
 
-// updateHash updates GlobalHashTable with the current position.
+// updateHash updates the transposition table with the current position.
 func (eng *Engine) updateHash(α, β, depth, score int32, move Move) {
+	if eng.isRestricted() {
+		// The score is only for the moves in SearchMoves.
+		return
+	}
 	kind := exact
 	if score <= α {
 		kind = failedLow
@@ -375,7 +396,7 @@ func (eng *Engine) updateHash(α, β, depth, score int32, move Move) {
 		}
 	}
 
-	GlobalHashTable.put(eng.Position, hashEntry{
+	eng.hashTable().put(eng.Position, hashEntry{
 		kind:  kind,
 		score: score,
 		depth: int8(depth),
@@ -388,17 +409,45 @@ func (eng *Engine) updateHash(α, β, depth, score int32, move Move) {
 // This is a very limited search which considers only violent moves.
 // Checks are not considered. In fact it assumes that the move
 // ordering will always put the king capture first.
-func (eng *Engine) searchQuiescence(α, β int32) int32 {
+//
+// In Racing Kings the race to the 8th rank is decided by quiet king
+// moves so they are searched, too: the king stepping to the 8th rank
+// and, at most race plies, the king stepping to the 7th rank and
+// the moves which stop the opponent from reaching the 8th rank next.
+func (eng *Engine) searchQuiescence(α, β, race int32) int32 {
 	eng.Stats.Nodes++
+	eng.triangularPV.clear(eng.ply())
 	if score, done := eng.endPosition(); done {
 		return score
 	}
 
+	pos := eng.Position
+	us := pos.SideToMove
+	inCheck := pos.IsChecked(us)
+	legal := newLegalFilter(pos)
+
+	///////////////////////////////////////////////////
+	// NEW
+	// threatened is true if the opponent's king reaches the 8th rank
+	// next move unless stopped, so the static evaluation is meaningless.
+	threatened := false
+	if Variant == VARIANT_Racing_Kings {
+		if legal.raceOnly {
+			return eng.searchRaceOnly(&legal)
+		}
+		threatened = race > 0 && pos.canReachBaseRank(us.Opposite())
+	}
+	///////////////////////////////////////////////////
+
 	// Stand pat.
 	// TODO: Some suggest to not stand pat when in check.
 	// However, I did several tests and handling checks in quiescence
 	// doesn't help at all.
 	static := eng.Score()
+	if threatened {
+		// If nothing stops the opponent it wins two plies later.
+		static = MatedScore + eng.ply() + 2
+	}
 	if static >= β {
 		return static
 	}
@@ -407,57 +456,123 @@ func (eng *Engine) searchQuiescence(α, β int32) int32 {
 		localα = static
 	}
 
-	pos := eng.Position
-	us := pos.SideToMove
-	inCheck := pos.IsChecked(us)
-
 	var bestMove Move
 	eng.stack.GenerateMoves(Violent, NullMove)
 	for move := eng.stack.PopMove(); move != NullMove; move = eng.stack.PopMove() {
+		// In Racing Kings a king capturing on the 8th rank wins the race.
+		critical := inCheck || threatened || isRaceGoal(move)
+
 		// Prune futile moves that would anyway result in a stand-pat
 		// at that next depth.
-		if !inCheck && isFutile(pos, static, localα, futilityMargin, move) {
+		if !critical && isFutile(pos, static, localα, futilityMargin, move) {
 			// TODO: should it update localα?
 			continue
 		}
 
-		// Discard illegal or losing captures.
-		eng.DoMove(move)
-		if eng.Position.IsChecked(us) ||
-			!inCheck && move.MoveType() == Normal && seeSign(pos, move) {
-			eng.UndoMove()
+		// Discard illegal moves. In Racing Kings captures that give check are illegal.
+		if !legal.isLegal(move) {
 			continue
 		}
 
-		///////////////////////////////////////////////////
-		// NEW
-		// In Racing Kings avoid captures that give check.
-		if Variant == VARIANT_Racing_Kings {
-			if eng.Position.IsCheckedLocal(us.Opposite()) {
-				eng.UndoMove()
-				continue
-			}
+		// Discard losing captures.
+		eng.DoMove(move)
+		if !critical && move.MoveType() == Normal && seeSign(pos, move) {
+			eng.UndoMove()
+			continue
 		}
-		///////////////////////////////////////////////////
 
-		score := -eng.searchQuiescence(-β, -localα)
+		score := -eng.searchQuiescence(-β, -localα, race)
 		eng.UndoMove()
 
 		if score >= β {
 			return score
 		}
 		if score > localα {
+			eng.triangularPV.update(eng.ply(), move)
 			localα = score
 			bestMove = move
 		}
 	}
 
+	///////////////////////////////////////////////////
+	// NEW
+	if Variant == VARIANT_Racing_Kings {
+		var moves []Move
+		if race > 0 && threatened {
+			pos.GenerateMoves(Quiet, &moves)
+		} else {
+			pos.GenerateFigureMoves(King, Quiet, &moves)
+		}
+
+		for _, move := range moves {
+			// The king stepping to the 8th rank ends the race,
+			// other moves use one of the race plies.
+			next := race - 1
+			if isRaceGoal(move) {
+				next = race
+			} else if race <= 0 {
+				continue
+			} else if !threatened && (move.Piece().Figure() != King ||
+				move.To().Rank() != 6 || move.From().Rank() >= 6) {
+				continue
+			}
+			if !legal.isLegal(move) {
+				continue
+			}
+
+			// Black's king stepping to the 7th rank is a defence, too,
+			// because it can follow White to the 8th rank for a draw.
+			follows := us == Black && move.Piece().Figure() == King && move.To().Rank() == 6
+
+			eng.DoMove(move)
+			if threatened && next < race && !follows && pos.canReachBaseRank(us.Opposite()) {
+				// The move doesn't stop the opponent.
+				eng.UndoMove()
+				continue
+			}
+			score := -eng.searchQuiescence(-β, -localα, next)
+			eng.UndoMove()
+
+			if score >= β {
+				return score
+			}
+			if score > localα {
+				eng.triangularPV.update(eng.ply(), move)
+				localα = score
+				bestMove = move
+			}
+		}
+	}
+	///////////////////////////////////////////////////
+
 	if α < localα && localα < β {
 		eng.pvTable.Put(eng.Position, bestMove)
 	}
 	return localα
 }
 
+///////////////////////////////////////////////////
+// NEW
+// isRaceGoal returns true if move is a Racing Kings king move to the 8th rank.
+func isRaceGoal(move Move) bool {
+	return Variant == VARIANT_Racing_Kings && move.Piece().Figure() == King && move.To().Rank() == 7
+}
+
+// searchRaceOnly scores a Racing Kings position where White has reached
+// the 8th rank and Black must follow with its king to draw, or lose.
+func (eng *Engine) searchRaceOnly(legal *legalFilter) int32 {
+	var moves []Move
+	eng.Position.GenerateFigureMoves(King, All, &moves)
+	for _, move := range moves {
+		if legal.isLegal(move) {
+			eng.pvTable.Put(eng.Position, move)
+			return 0
+		}
+	}
+	return MatedScore + eng.ply()
+}
+///////////////////////////////////////////////////
+
 // tryMove makes a move and descends on the search tree.
 //
 // α, β represent lower and upper bounds.
@@ -496,6 +611,41 @@ func (eng *Engine) ply() int32 {
 	return int32(eng.Position.Ply - eng.rootPly)
 }
 
+// isRestricted returns true if the search is at root
+// and only the moves in TimeControl.SearchMoves are searched.
+func (eng *Engine) isRestricted() bool {
+	return eng.ply() == 0 && len(eng.timeControl.SearchMoves) != 0
+}
+
+// isSearchMove returns true if move can be searched at root.
+func (eng *Engine) isSearchMove(move Move) bool {
+	for _, m := range eng.timeControl.SearchMoves {
+		if m == move {
+			return true
+		}
+	}
+	return false
+}
+
+// outOfNodes returns true if the node limit was reached.
+// The search is not stopped before the first depth
+// that found a move, so there is always a move to play.
+func (eng *Engine) outOfNodes() bool {
+	tc := eng.timeControl
+	if tc.Nodes == 0 {
+		return false
+	}
+	if eng.Stats.Nodes >= tc.Nodes && tc.bestMove != NullMove {
+		tc.Stop()
+		return true
+	}
+	if eng.Stats.Nodes < tc.Nodes && eng.checkpoint > tc.Nodes {
+		// Check again exactly when the limit is reached.
+		eng.checkpoint = tc.Nodes
+	}
+	return false
+}
+
 // passed returns true if a passed pawn appears or disappears.
 //
 // TODO: The heuristic is incomplete and doesn't handled discovered passed pawns.
@@ -548,7 +698,7 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 	eng.Stats.Nodes++
 	if !eng.stopped && eng.Stats.Nodes >= eng.checkpoint {
 		eng.checkpoint = eng.Stats.Nodes + checkpointStep
-		if eng.timeControl.Stopped() {
+		if eng.timeControl.Stopped() || eng.outOfNodes() {
 			eng.stopped = true
 		}
 	}
@@ -558,6 +708,7 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 	if pvNode && ply > eng.Stats.SelDepth {
 		eng.Stats.SelDepth = eng.ply()
 	}
+	eng.triangularPV.clear(ply)
 
 	// Verify that this is not already an endgame.
 	if score, done := eng.endPosition(); done {
@@ -599,7 +750,7 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 	// Stop searching when the maximum search depth is reached.
 	if depth <= 0 {
 		// Depth can be < 0 due to aggressive LMR.
-		score := eng.searchQuiescence(α, β)
+		score := eng.searchQuiescence(α, β, raceQuiescenceDepth)
 		eng.updateHash(α, β, depth, score, NullMove)
 		return score
 	}
@@ -654,6 +805,11 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 	numQuiet := int32(0)
 	localα := α
 
+	// quiets are the quiet moves searched without a cutoff.
+	var quietsBuffer [64]Move
+	quiets := quietsBuffer[:0]
+
+	legal := newLegalFilter(pos)
 	eng.stack.GenerateMoves(All, hash)
 	for move := eng.stack.PopMove(); move != NullMove; move = eng.stack.PopMove() {
 		critical := move == hash || eng.stack.IsKiller(move)
@@ -661,25 +817,18 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 			numQuiet++ // TODO: Move from here.
 		}
 
-		newDepth := depth
-		eng.DoMove(move)
-
 		// Skip illegal moves that leave the king in check.
-		if pos.IsChecked(us) {
-			eng.UndoMove()
+		// In Racing Kings moves that give check are skipped, too.
+		if !legal.isLegal(move) {
 			continue
 		}
-
-		///////////////////////////////////////////////////
-		// NEW
-		// In Racing Kings skip moves that give check.
-		if Variant == VARIANT_Racing_Kings {
-			if pos.IsCheckedLocal(us.Opposite()) {
-				eng.UndoMove()
-				continue
-			}
+		// At root skip the moves not requested by the GUI.
+		if eng.isRestricted() && !eng.isSearchMove(move) {
+			continue
 		}
-		///////////////////////////////////////////////////
+
+		newDepth := depth
+		eng.DoMove(move)
 
 		// Extend the search when our move gives check.
 		// However do not extend if we can just take the undefended piece.
@@ -731,10 +880,17 @@ func (eng *Engine) searchTree(α, β, depth int32) int32 {
 		}
 		if score >= β { // Fail high, cut node.
 			eng.stack.SaveKiller(move)
+			eng.stack.SaveCutoff(move, quiets, depth)
 			eng.updateHash(α, β, depth, score, move)
 			return score
 		}
+		if move.IsQuiet() && len(quiets) < cap(quiets) {
+			quiets = append(quiets, move)
+		}
 		if score > bestScore {
+			if score > localα {
+				eng.triangularPV.update(ply, move)
+			}
 			nullWindow = true
 			bestMove, bestScore = move, score
 			localα = max(localα, score)
@@ -802,6 +958,7 @@ func (eng *Engine) search(depth, estimated int32) int32 {
 // Returns the principal variation, that is
 //	moves[0] is the best move found and
 //	moves[1] is the pondering move.
+// The pondering move is present whenever the opponent can move.
 //
 // If no move was found because the game has finished
 // then an empty pv is returned.
@@ -815,10 +972,22 @@ func (eng *Engine) Play(tc *TimeControl) (moves []Move) {
 	eng.timeControl = tc
 	eng.stopped = false
 	eng.checkpoint = checkpointStep
+	if tc.Nodes != 0 && tc.Nodes < eng.checkpoint {
+		eng.checkpoint = tc.Nodes
+	}
 	eng.stack.Reset(eng.Position)
-
+	eng.pvTable.hashTable = eng.hashTable()
+	eng.pvTable.hashTable.NewSearch()
+
+	numMoves := len(eng.Position.GetLegalMoves(GET_ALL))
+	start := int32(0)
+	if len(tc.SearchMoves) != 0 {
+		// Depth 0 is only a quiescence search which
+		// cannot be restricted to the requested moves.
+		numMoves, start = len(tc.SearchMoves), 1
+	}
 	score := int32(0)
-	for depth := int32(0); depth < 64; depth++ {
+	for depth := start; depth < 64; depth++ {
 		if !tc.NextDepth(depth) {
 			// Stop if tc control says we are done.
 			// Search at least one depth, otherwise a move cannot be returned.
@@ -830,8 +999,16 @@ func (eng *Engine) Play(tc *TimeControl) (moves []Move) {
 
 		if !eng.stopped {
 			// if eng has not been stopped then this is a legit pv.
-			moves = eng.pvTable.Get(eng.Position)
+			if eng.Options.TriangularPV {
+				moves = eng.triangularPV.Get()
+			} else {
+				moves = eng.pvTable.Get(eng.Position)
+			}
+			moves = addPonderMove(eng.Position, moves)
 			eng.Log.PrintPV(eng.Stats, score, moves)
+			if len(moves) != 0 {
+				tc.IterationDone(moves[0], score, numMoves)
+			}
 		}
 	}
 
@@ -22,61 +22,114 @@ const (
 
 // hashEntry is a value in the transposition table.
 type hashEntry struct {
-	lock  uint32   // lock is used to handle hashing conflicts.
-	move  Move     // best move
-	score int32    // score of the position. if mate, score is relative to current position.
-	depth int8     // remaining search depth
-	kind  hashKind // type of hash
+	lock       uint32   // lock is used to handle hashing conflicts.
+	move       Move     // best move
+	score      int32    // score of the position. if mate, score is relative to current position.
+	depth      int8     // remaining search depth
+	kind       hashKind // type of hash
+	generation uint8    // search generation when the entry was last used
 }
 
+// hashBucketSize is the number of entries in a bucket.
+// A bucket of four 16 bytes entries fits a cache line.
+const hashBucketSize = 4
+
+// hashBucket holds the entries of positions with the same index.
+type hashBucket [hashBucketSize]hashEntry
+
+// hashfullSamples is the number of buckets sampled by Hashfull.
+const hashfullSamples = 250
+
 // HashTable is a transposition table.
 // Engine uses this table to cache position scores so
 // it doesn't have to research them again.
 type HashTable struct {
-	table []hashEntry // len(table) is a power of two and equals mask+1
-	mask  uint32      // mask is used to determine the index in the table.
+	table      []hashBucket // len(table) is a power of two and equals mask+1
+	mask       uint32       // mask is used to determine the index in the table.
+	generation uint8        // generation of the current search
 }
 
 // NewHashTable builds transposition table that takes up to hashSizeMB megabytes.
 func NewHashTable(hashSizeMB int) *HashTable {
 	// Choose hashSize such that it is a power of two.
-	hashEntrySize := uint64(unsafe.Sizeof(hashEntry{}))
-	hashSize := uint64(hashSizeMB) << 20 / hashEntrySize
+	hashBucketSize := uint64(unsafe.Sizeof(hashBucket{}))
+	hashSize := uint64(hashSizeMB) << 20 / hashBucketSize
 
 	for hashSize&(hashSize-1) != 0 {
 		hashSize &= hashSize - 1
 	}
+	if hashSize == 0 {
+		hashSize = 1
+	}
 	return &HashTable{
-		table: make([]hashEntry, hashSize),
+		table: make([]hashBucket, hashSize),
 		mask:  uint32(hashSize - 1),
 	}
 }
 
 // Size returns the number of entries in the table.
 func (ht *HashTable) Size() int {
-	return int(ht.mask + 1)
+	return int(ht.mask+1) * hashBucketSize
+}
+
+// NewSearch starts a new search generation.
+// Entries not used in the current generation are replaced first.
+func (ht *HashTable) NewSearch() {
+	ht.generation++
+}
+
+// Hashfull returns how full is the table in permille.
+// Only entries of the current generation are counted.
+// The value is estimated from the first few buckets.
+func (ht *HashTable) Hashfull() int {
+	n := min(int32(len(ht.table)), hashfullSamples)
+	used := 0
+	for i := range ht.table[:n] {
+		for j := range ht.table[i] {
+			if e := &ht.table[i][j]; e.kind != noEntry && e.generation == ht.generation {
+				used++
+			}
+		}
+	}
+	return used * 1000 / (int(n) * hashBucketSize)
 }
 
-// split splits lock into a lock and two hash table indexes.
-// expects mask to be at least 3 bits.
-func split(lock uint64, mask uint32) (uint32, uint32, uint32) {
+// split splits lock into a lock and a hash table index.
+func split(lock uint64, mask uint32) (uint32, uint32) {
 	hi := uint32(lock >> 32)
 	lo := uint32(lock)
-	h0 := lo & mask
-	h1 := h0 ^ (lo >> 29)
-	return hi, h0, h1
+	return hi, lo & mask
 }
 
 // put puts a new entry in the database.
+//
+// The entry replaces the entry of the same position, if any.
+// Otherwise the entry with the lowest depth is replaced,
+// each generation of age counting as several plies of depth.
 func (ht *HashTable) put(pos *Position, entry hashEntry) {
-	lock, key0, key1 := split(pos.Zobrist(), ht.mask)
+	lock, key := split(pos.Zobrist(), ht.mask)
 	entry.lock = lock
-
-	if e := &ht.table[key0]; e.lock == lock || e.kind == noEntry || e.depth+1 >= entry.depth {
-		ht.table[key0] = entry
-	} else {
-		ht.table[key1] = entry
+	entry.generation = ht.generation
+
+	bucket := &ht.table[key]
+	replace := &bucket[0]
+	for i := range bucket {
+		e := &bucket[i]
+		if e.lock == lock || e.kind == noEntry {
+			replace = e
+			break
+		}
+		if ht.worth(e) < ht.worth(replace) {
+			replace = e
+		}
 	}
+	*replace = entry
+}
+
+// worth returns how valuable e is to keep in the table.
+func (ht *HashTable) worth(e *hashEntry) int32 {
+	age := int32(ht.generation - e.generation)
+	return int32(e.depth) - 8*age
 }
 
 // get returns the hash entry for position.
@@ -85,12 +138,13 @@ func (ht *HashTable) put(pos *Position, entry hashEntry) {
 // from a different table. However, these errors are not common because
 // we use 32-bit lock + log_2(len(ht.table)) bits to avoid collisions.
 func (ht *HashTable) get(pos *Position) hashEntry {
-	lock, key0, key1 := split(pos.Zobrist(), ht.mask)
-	if ht.table[key0].lock == lock {
-		return ht.table[key0]
-	}
-	if ht.table[key1].lock == lock {
-		return ht.table[key1]
+	lock, key := split(pos.Zobrist(), ht.mask)
+	bucket := &ht.table[key]
+	for i := range bucket {
+		if e := &bucket[i]; e.lock == lock && e.kind != noEntry {
+			e.generation = ht.generation
+			return *e
+		}
 	}
 	return hashEntry{}
 }
@@ -98,8 +152,9 @@ func (ht *HashTable) get(pos *Position) hashEntry {
 // Clear removes all entries from hash.
 func (ht *HashTable) Clear() {
 	for i := range ht.table {
-		ht.table[i] = hashEntry{}
+		ht.table[i] = hashBucket{}
 	}
+	ht.generation = 0
 }
 
 func init() {
@@ -0,0 +1,241 @@
+// legal.go implements legal move generation.
+//
+// Instead of executing every pseudo-legal move and testing whether
+// the king is left in check, the legality of a move is decided
+// from pin masks and check evasion masks computed once per position.
+// For Racing Kings the moves that give check are excluded using the
+// squares from which each figure attacks the enemy king.
+
+package engine
+
+// legalFilter keeps the information needed to decide whether
+// a pseudo-legal move is legal in the position it was built for.
+type legalFilter struct {
+	pos *Position
+
+	king     Square   // our king's square
+	noKing   bool     // true if we have no king
+	checkers Bitboard // enemy pieces giving check to our king
+	evasion  Bitboard // squares where non-king moves must land when in check
+	pinned   Bitboard // our pieces pinned to our king
+
+	racingKings  bool                      // true to exclude moves that give check
+	slow         bool                      // true to execute every move to test legality
+	raceOnly     bool                      // true if only king moves to the 8th rank are allowed
+	over         bool                      // true if the race has ended so there are no legal moves
+	enemyKing    Square                    // enemy king's square
+	discoverers  Bitboard                  // our pieces that block our sliders from the enemy king
+	checkSquares [FigureArraySize]Bitboard // squares from which each figure checks the enemy king
+}
+
+// newLegalFilter computes the pins and checks for the current position.
+func newLegalFilter(pos *Position) legalFilter {
+	us := pos.SideToMove
+	them := us.Opposite()
+	all := pos.ByColor[White] | pos.ByColor[Black]
+
+	lf := legalFilter{
+		pos:     pos,
+		evasion: BbFull,
+	}
+
+	if king := pos.ByPiece(us, King); king == 0 {
+		lf.noKing = true
+	} else {
+		lf.king = king.AsSquare()
+		lf.checkers = pos.attackers(lf.king, them, all)
+		if lf.checkers != 0 {
+			if lf.checkers&(lf.checkers-1) != 0 {
+				// Double check, only the king can move.
+				lf.evasion = BbEmpty
+			} else {
+				// Capture or block the checker.
+				checker := lf.checkers.AsSquare()
+				lf.evasion = lf.checkers | bbBetween[lf.king][checker]
+			}
+		}
+		lf.pinned = pos.blockers(lf.king, them, all) & pos.ByColor[us]
+	}
+
+	if Variant == VARIANT_Racing_Kings {
+		lf.racingKings = true
+		// If White has reached the 8th rank then the only legal
+		// moves of Black are its king reaching it, too.
+		// In every other position with a king on the 8th rank the game is over.
+		lf.raceOnly = us == Black && pos.IsOnBaseRank(White) && !pos.IsOnBaseRank(Black)
+		lf.over = !lf.raceOnly && (pos.IsOnBaseRank(White) || pos.IsOnBaseRank(Black))
+
+		if king := pos.ByPiece(them, King); king != 0 {
+			lf.enemyKing = king.AsSquare()
+			if pos.attackers(lf.enemyKing, us, all) != 0 {
+				// The enemy king is already checked, which cannot happen
+				// in a legal position, so the check squares are not reliable.
+				lf.slow = true
+			}
+			ek := lf.enemyKing.Bitboard()
+			bishop := BishopMobility(lf.enemyKing, all)
+			rook := RookMobility(lf.enemyKing, all)
+
+			lf.discoverers = pos.blockers(lf.enemyKing, us, all) & pos.ByColor[us]
+			lf.checkSquares[Pawn] = Backward(us, West(ek)|East(ek))
+			lf.checkSquares[Knight] = bbKnightAttack[lf.enemyKing]
+			lf.checkSquares[Bishop] = bishop
+			lf.checkSquares[Rook] = rook
+			lf.checkSquares[Queen] = bishop | rook
+		}
+	}
+
+	return lf
+}
+
+// IsLegal returns true if m is a legal move in the current position
+// following the rules of the current variant. Unlike IsPseudoLegal
+// the move cannot leave the king in check, and in Racing Kings it
+// cannot give check or be played after the race has ended.
+//
+// m can be any move, e.g. parsed from user input, so it is
+// compared against the moves generated for its figure instead
+// of trusting IsPseudoLegal which expects a well formed move.
+func (pos *Position) IsLegal(m Move) bool {
+	if m == NullMove || m.SideToMove() != pos.SideToMove {
+		return false
+	}
+	var moves []Move
+	pos.GenerateFigureMoves(m.Piece().Figure(), All, &moves)
+	for _, pm := range moves {
+		if pm == m {
+			lf := newLegalFilter(pos)
+			return lf.isLegal(m)
+		}
+	}
+	return false
+}
+
+// isLegal returns true if the pseudo-legal move m is legal.
+// m must have been generated for the position of the filter.
+func (lf *legalFilter) isLegal(m Move) bool {
+	pos := lf.pos
+	from, to := m.From(), m.To()
+
+	if lf.over {
+		return false
+	}
+	if lf.raceOnly && (m.Piece().Figure() != King || to.Rank() != 7) {
+		return false
+	}
+	if lf.slow || m.MoveType() == Enpassant || m.MoveType() == Castling && lf.racingKings {
+		// En passant can discover checks along the ranks and
+		// castling moves the rook, so both are executed.
+		// They are rare enough to not matter for speed.
+		return lf.isLegalSlow(m)
+	}
+
+	if m.Piece().Figure() == King {
+		if m.MoveType() == Normal {
+			// The king cannot step into an attacked square.
+			// The king is removed so it doesn't block sliders.
+			all := (pos.ByColor[White] | pos.ByColor[Black]) &^ from.Bitboard()
+			if pos.attackers(to, pos.SideToMove.Opposite(), all) != 0 {
+				return false
+			}
+		}
+		// Castling is generated only if the squares are not attacked.
+	} else if !lf.noKing {
+		if !lf.evasion.Has(to) {
+			return false
+		}
+		if lf.pinned.Has(from) && !bbLine[lf.king][from].Has(to) {
+			return false
+		}
+	}
+
+	return !lf.racingKings || !lf.givesCheck(m)
+}
+
+// givesCheck returns true if m gives check to the enemy king.
+// Only valid for Racing Kings filters.
+func (lf *legalFilter) givesCheck(m Move) bool {
+	if lf.pos.ByPiece(lf.pos.SideToMove.Opposite(), King) == 0 {
+		return false
+	}
+	if m.MoveType() == Promotion {
+		// The pawn may have blocked the promoted piece's line.
+		return lf.isCheckSlow(m)
+	}
+	from, to := m.From(), m.To()
+	if lf.checkSquares[m.Target().Figure()].Has(to) {
+		return true
+	}
+	if lf.discoverers.Has(from) && !bbLine[lf.enemyKing][from].Has(to) {
+		return true
+	}
+	return false
+}
+
+// isLegalSlow executes m to find whether it is legal.
+func (lf *legalFilter) isLegalSlow(m Move) bool {
+	pos := lf.pos
+	us := pos.SideToMove
+	pos.DoMove(m)
+	checked := pos.IsChecked(us)
+	if lf.racingKings {
+		checked = checked || pos.IsCheckedLocal(us.Opposite())
+	}
+	pos.UndoMove()
+	return !checked
+}
+
+// isCheckSlow executes m to find whether it gives check.
+func (lf *legalFilter) isCheckSlow(m Move) bool {
+	pos := lf.pos
+	pos.DoMove(m)
+	checked := pos.IsCheckedLocal(pos.SideToMove)
+	pos.UndoMove()
+	return checked
+}
+
+// attackers returns the pieces of color them attacking sq given all occupancy.
+func (pos *Position) attackers(sq Square, them Color, all Bitboard) Bitboard {
+	bb := sq.Bitboard()
+	enemy := pos.ByColor[them]
+	att := Backward(them, West(bb)|East(bb)) & pos.ByFigure[Pawn]
+	att |= bbKnightAttack[sq] & pos.ByFigure[Knight]
+	att |= bbKingAttack[sq] & pos.ByFigure[King]
+	att |= BishopMobility(sq, all) & (pos.ByFigure[Bishop] | pos.ByFigure[Queen])
+	att |= RookMobility(sq, all) & (pos.ByFigure[Rook] | pos.ByFigure[Queen])
+	return att & enemy
+}
+
+// blockers returns the pieces that are the only piece between sq
+// and a slider of color them.
+func (pos *Position) blockers(sq Square, them Color, all Bitboard) Bitboard {
+	bishops := pos.ByPiece(them, Bishop) | pos.ByPiece(them, Queen)
+	rooks := pos.ByPiece(them, Rook) | pos.ByPiece(them, Queen)
+	snipers := BishopMobility(sq, BbEmpty)&bishops | RookMobility(sq, BbEmpty)&rooks
+
+	var blockers Bitboard
+	for snipers != 0 {
+		between := bbBetween[sq][snipers.Pop()] & all
+		if between != 0 && between&(between-1) == 0 {
+			blockers |= between
+		}
+	}
+	return blockers
+}
+
+// GenerateLegalMoves appends to moves all legal moves of kind.
+// In Racing Kings moves giving check are not legal.
+// kind is a combination of Quiet, Tactical or Violent.
+func (pos *Position) GenerateLegalMoves(kind int, moves *[]Move) {
+	start := len(*moves)
+	pos.GenerateMoves(kind, moves)
+
+	lf := newLegalFilter(pos)
+	legal := (*moves)[:start]
+	for _, m := range (*moves)[start:] {
+		if lf.isLegal(m) {
+			legal = append(legal, m)
+		}
+	}
+	*moves = legal
+}
@@ -4,6 +4,7 @@ package engine
 
 import (
 	"fmt"
+	"strings"
 )
 
 const (
@@ -63,15 +64,11 @@ var (
 	wRookOnOpenFile     Score
 	wRookOnHalfOpenFile Score
 
-	// Evaluation caches.
-	pawnsAndShelterCache *cache
 )
 
 const ()
 
 func init() {
-	// Initialize caches.
-	pawnsAndShelterCache = newCache(9, hashPawnsAndShelter, evaluatePawnsAndShelter)
 	initWeights()
 
 	slice := func(w []Score, out []Score) []Score {
@@ -105,7 +102,7 @@ func init() {
 }
 
 func hashPawnsAndShelter(pos *Position, us Color) uint64 {
-	h := murmurSeed[us]
+	h := murmurSeed[us] ^ zobristVariant[Variant]
 	h = murmurMix(h, uint64(pos.ByPiece(us, Pawn)))
 	h = murmurMix(h, uint64(pos.ByPiece(us.Opposite(), Pawn)))
 	h = murmurMix(h, uint64(pos.ByPiece(us, King)))
@@ -126,32 +123,7 @@ func evaluatePawnsAndShelter(pos *Position, us Color) Eval {
 func evaluatePawns(pos *Position, us Color) Eval {
 	var eval Eval
 	ours := pos.ByPiece(us, Pawn)
-	theirs := pos.ByPiece(us.Opposite(), Pawn)
-
-	// From white's POV (P - white pawn, p - black pawn).
-	// block   wings
-	// ....... .....
-	// .....P. .....
-	// .....x. .....
-	// ..p..x. .....
-	// .xxx.x. .xPx.
-	// .xxx.x. .....
-	// .xxx.x. .....
-	// .xxx.x. .....
-	block := East(theirs) | theirs | West(theirs)
-	wings := East(ours) | West(ours)
-	double := Bitboard(0)
-	if us == White {
-		block = SouthSpan(block) | SouthSpan(ours)
-		double = ours & South(ours)
-	} else /* if us == Black */ {
-		block = NorthSpan(block) | NorthSpan(ours)
-		double = ours & North(ours)
-	}
-
-	isolated := ours &^ Fill(wings)                           // no pawn on the adjacent files
-	connected := ours & (North(wings) | wings | South(wings)) // has neighbouring pawns
-	passed := ours &^ block                                   // no pawn env front and no enemy on the adjacent files
+	passed, connected, double, isolated := pawnStructure(pos, us)
 
 	for bb := ours; bb != 0; {
 		sq := bb.Pop()
@@ -178,6 +150,37 @@ func evaluatePawns(pos *Position, us Color) Eval {
 	return eval
 }
 
+// pawnStructure returns the passed, connected, doubled and isolated pawns of us.
+func pawnStructure(pos *Position, us Color) (passed, connected, double, isolated Bitboard) {
+	ours := pos.ByPiece(us, Pawn)
+	theirs := pos.ByPiece(us.Opposite(), Pawn)
+
+	// From white's POV (P - white pawn, p - black pawn).
+	// block   wings
+	// ....... .....
+	// .....P. .....
+	// .....x. .....
+	// ..p..x. .....
+	// .xxx.x. .xPx.
+	// .xxx.x. .....
+	// .xxx.x. .....
+	// .xxx.x. .....
+	block := East(theirs) | theirs | West(theirs)
+	wings := East(ours) | West(ours)
+	if us == White {
+		block = SouthSpan(block) | SouthSpan(ours)
+		double = ours & South(ours)
+	} else /* if us == Black */ {
+		block = NorthSpan(block) | NorthSpan(ours)
+		double = ours & North(ours)
+	}
+
+	isolated = ours &^ Fill(wings)                           // no pawn on the adjacent files
+	connected = ours & (North(wings) | wings | South(wings)) // has neighbouring pawns
+	passed = ours &^ block                                   // no pawn env front and no enemy on the adjacent files
+	return passed, connected, double, isolated
+}
+
 func evaluateShelter(pos *Position, us Color) Eval {
 	var eval Eval
 	pawns := pos.ByPiece(us, Pawn)
@@ -203,9 +206,20 @@ func evaluateShelter(pos *Position, us Color) Eval {
 	return eval
 }
 
+// newPawnsAndShelterCache returns a new cache for evaluatePawnsAndShelter.
+// Each engine has its own cache, so the cache needs no locking.
+func newPawnsAndShelterCache() *cache {
+	return newCache(9, hashPawnsAndShelter, evaluatePawnsAndShelter)
+}
+
 // evaluateSide evaluates position for a single side.
-func evaluateSide(pos *Position, us Color, eval *Eval) {
-	eval.Merge(pawnsAndShelterCache.load(pos, us))
+// c caches the pawns and shelter evaluation, nil for no cache.
+func evaluateSide(pos *Position, us Color, eval *Eval, c *cache) {
+	if c != nil {
+		eval.Merge(c.load(pos, us))
+	} else {
+		eval.Merge(evaluatePawnsAndShelter(pos, us))
+	}
 	all := pos.ByColor[White] | pos.ByColor[Black]
 	them := us.Opposite()
 
@@ -270,28 +284,52 @@ func evaluateSide(pos *Position, us Color, eval *Eval) {
 
 // evaluatePosition evalues position.
 func EvaluatePosition(pos *Position) Eval {
+	return evaluatePosition(pos, nil)
+}
+
+// evaluatePosition evalues position using the cache c.
+func evaluatePosition(pos *Position, c *cache) Eval {
 	var eval Eval
-	evaluateSide(pos, Black, &eval)
+	evaluateSide(pos, Black, &eval, c)
 	eval.Neg()
-	evaluateSide(pos, White, &eval)
+	evaluateSide(pos, White, &eval, c)
 	return eval
 }
 
 ///////////////////////////////////////////////////
 // NEW
+// EvaluateSideRk evaluates position for a single side in Racing Kings.
+//
+// The evaluation uses the accumulators maintained by Position.Put
+// and Position.Remove so it runs in constant time.
 func EvaluateSideRk(pos *Position, side Color) int32 {
 	var val int32 = 0
 	// piece values
-	for piece := Knight ; piece < King ; piece++ {
+	for piece := Knight; piece < King; piece++ {
+		val += pos.numPieces[side][piece] * RK_PIECE_VALUES[piece]
+	}
+	// king advance value
+	val += pos.sumRanks[side][King] * KING_ADVANCE_VALUE
+	// knight advance value
+	val += pos.sumRanks[side][Knight] * KNIGHT_ADVANCE_VALUE
+	return val
+}
+
+// evaluateSideRkFull is the same as EvaluateSideRk, but computes
+// the evaluation from scratch. Used to verify the incremental evaluation.
+func evaluateSideRkFull(pos *Position, side Color) int32 {
+	var val int32 = 0
+	// piece values
+	for piece := Knight; piece < King; piece++ {
 		num := pos.ByPiece(side, piece).Count()
 		val += num * RK_PIECE_VALUES[piece]
 	}
 	// king advance value
-	val += int32(pos.ByPiece(side, King).AsSquare().Rank())*KING_ADVANCE_VALUE
+	val += int32(pos.ByPiece(side, King).AsSquare().Rank()) * KING_ADVANCE_VALUE
 	// knight advance value
 	for bb := pos.ByPiece(side, Knight); bb > 0; {
 		sq := bb.Pop()
-		val += int32(sq.Rank())*KNIGHT_ADVANCE_VALUE
+		val += int32(sq.Rank()) * KNIGHT_ADVANCE_VALUE
 	}
 	return val
 }
@@ -299,12 +337,24 @@ func EvaluateSideRk(pos *Position, side Color) int32 {
 
 // Evaluate evaluates position from White's POV.
 func Evaluate(pos *Position) int32 {
+	return evaluate(pos, nil)
+}
+
+// evaluate evaluates position from White's POV using the cache c.
+func evaluate(pos *Position, c *cache) int32 {
 	///////////////////////////////////////////////////
 	// NEW
 	if Variant == VARIANT_Racing_Kings {
 		evalw := EvaluateSideRk(pos, White)
 		evalb := EvaluateSideRk(pos, Black)
 
+		if DEBUG_INCREMENTAL_EVAL {
+			if fullw, fullb := evaluateSideRkFull(pos, White), evaluateSideRkFull(pos, Black); fullw != evalw || fullb != evalb {
+				panic(fmt.Sprintf("incremental eval %d/%d differs from full eval %d/%d for %v",
+					evalw, evalb, fullw, fullb, pos))
+			}
+		}
+
 		eval := evalw - evalb
 
 		score := eval*128
@@ -312,7 +362,7 @@ func Evaluate(pos *Position) int32 {
 		return score
 	}
 	///////////////////////////////////////////////////
-	eval := EvaluatePosition(pos)
+	eval := evaluatePosition(pos, c)
 	score := eval.Feed(Phase(pos))
 	if KnownLossScore >= score || score >= KnownWinScore {
 		panic(fmt.Sprintf("score %d should be between %d and %d",
@@ -338,3 +388,188 @@ func Phase(pos *Position) int32 {
 	curr -= pos.ByFigure[Queen].Count() * 4
 	return (curr*256 + total/2) / total
 }
+
+// EvalTerm is the contribution of one evaluation term for both sides.
+type EvalTerm struct {
+	Name  string
+	Value [ColorArraySize]Eval // indexed by color, from each side's POV
+}
+
+// EvalTrace is the evaluation of a position broken down by term.
+//
+// The terms of each side add up to the evaluation of Evaluate
+// save for rounding. In Racing Kings the mid game and end game
+// values are equal so the phase doesn't matter.
+type EvalTrace struct {
+	Variant int        // variant evaluated
+	Terms   []EvalTerm // terms in the order they are evaluated
+	Phase   int32      // game phase, 0 is opening, 256 is late end game
+	Score   int32      // evaluation from White's POV, same as Evaluate
+}
+
+var (
+	// materialTerms are the names of the material terms by figure.
+	materialTerms = [FigureArraySize]string{
+		Pawn:   "material pawn",
+		Knight: "material knight",
+		Bishop: "material bishop",
+		Rook:   "material rook",
+		Queen:  "material queen",
+	}
+
+	standardTerms = []string{
+		materialTerms[Pawn], materialTerms[Knight], materialTerms[Bishop],
+		materialTerms[Rook], materialTerms[Queen],
+		"pawn squares", "passed pawns", "connected pawns", "doubled pawns",
+		"isolated pawns", "pawn threats", "mobility", "bishop pair",
+		"rook on open file", "rook on half open file", "king shelter",
+	}
+
+	racingKingsTerms = []string{
+		materialTerms[Knight], materialTerms[Bishop],
+		materialTerms[Rook], materialTerms[Queen],
+		"king advance", "knight advance",
+	}
+)
+
+// EvaluateTrace evaluates pos like Evaluate and returns
+// the contribution of each term for each side.
+func EvaluateTrace(pos *Position) *EvalTrace {
+	tr := &EvalTrace{
+		Variant: Variant,
+		Phase:   Phase(pos),
+		Score:   Evaluate(pos),
+	}
+
+	if Variant == VARIANT_Racing_Kings {
+		tr.setTerms(racingKingsTerms)
+		for col := ColorMinValue; col <= ColorMaxValue; col++ {
+			for fig := Knight; fig < King; fig++ {
+				tr.term(materialTerms[fig]).Value[col] = rkEval(pos.numPieces[col][fig] * RK_PIECE_VALUES[fig])
+			}
+			tr.term("king advance").Value[col] = rkEval(pos.sumRanks[col][King] * KING_ADVANCE_VALUE)
+			tr.term("knight advance").Value[col] = rkEval(pos.sumRanks[col][Knight] * KNIGHT_ADVANCE_VALUE)
+		}
+		return tr
+	}
+
+	tr.setTerms(standardTerms)
+	for col := ColorMinValue; col <= ColorMaxValue; col++ {
+		tr.traceSide(pos, col)
+	}
+	return tr
+}
+
+// rkEval converts a Racing Kings evaluation to the scale of Evaluate.
+func rkEval(val int32) Eval {
+	return Eval{M: val * 128, E: val * 128}
+}
+
+// setTerms adds the terms named names.
+func (tr *EvalTrace) setTerms(names []string) {
+	tr.Terms = make([]EvalTerm, len(names))
+	for i, name := range names {
+		tr.Terms[i].Name = name
+	}
+}
+
+// term returns the term named name.
+func (tr *EvalTrace) term(name string) *EvalTerm {
+	for i := range tr.Terms {
+		if tr.Terms[i].Name == name {
+			return &tr.Terms[i]
+		}
+	}
+	panic("unknown evaluation term " + name)
+}
+
+// traceSide splits the standard evaluation of us by term.
+// It must be kept in sync with evaluateSide.
+func (tr *EvalTrace) traceSide(pos *Position, us Color) {
+	add := func(name string, s Score, n int32) {
+		tr.term(name).Value[us].AddN(s, n)
+	}
+
+	all := pos.ByColor[White] | pos.ByColor[Black]
+	them := us.Opposite()
+
+	// Material.
+	for fig := Pawn; fig < King; fig++ {
+		add(materialTerms[fig], wFigure[fig], pos.ByPiece(us, fig).Count())
+	}
+
+	// Pawns.
+	passed, connected, double, isolated := pawnStructure(pos, us)
+	for bb := pos.ByPiece(us, Pawn); bb != 0; {
+		sq := bb.Pop()
+		povSq := sq.POV(us)
+		add("pawn squares", wPawn[povSq-8], 1)
+		if passed.Has(sq) {
+			add("passed pawns", wPassedPawn[povSq.Rank()], 1)
+		}
+	}
+	add("connected pawns", wConnectedPawn, connected.Count())
+	add("doubled pawns", wDoublePawn, double.Count())
+	add("isolated pawns", wIsolatedPawn, isolated.Count())
+	add("pawn threats", wPawnThreat, (pos.PawnThreats(us) & pos.ByColor[them]).Count())
+	tr.term("king shelter").Value[us].Merge(evaluateShelter(pos, us))
+
+	// Mobility and piece placement.
+	mobility := Forward(us, pos.ByPiece(us, Pawn)) &^ all
+	add("mobility", wMobility[Pawn], mobility.Count())
+	excl := pos.ByPiece(us, Pawn) | pos.PawnThreats(them)
+	for bb := pos.ByPiece(us, Knight); bb > 0; {
+		add("mobility", wMobility[Knight], (KnightMobility(bb.Pop()) &^ excl).Count())
+	}
+	for bb := pos.ByPiece(us, Bishop); bb > 0; {
+		add("mobility", wMobility[Bishop], (BishopMobility(bb.Pop(), all) &^ excl).Count())
+	}
+	add("bishop pair", wBishopPair, pos.ByPiece(us, Bishop).Count()/2)
+	for bb := pos.ByPiece(us, Rook); bb > 0; {
+		sq := bb.Pop()
+		add("mobility", wMobility[Rook], (RookMobility(sq, all) &^ excl).Count())
+		if f := FileBb(sq.File()); pos.ByPiece(us, Pawn)&f == 0 {
+			if pos.ByPiece(them, Pawn)&f == 0 {
+				add("rook on open file", wRookOnOpenFile, 1)
+			} else {
+				add("rook on half open file", wRookOnHalfOpenFile, 1)
+			}
+		}
+	}
+	for bb := pos.ByPiece(us, Queen); bb > 0; {
+		add("mobility", wMobility[Queen], (QueenMobility(bb.Pop(), all) &^ excl).Count())
+	}
+	add("mobility", wMobility[King], (KingMobility(pos.ByPiece(us, King).AsSquare()) &^ excl).Count())
+}
+
+// String returns the trace as a table in centipawns.
+func (tr *EvalTrace) String() string {
+	cp := func(e Eval) float64 {
+		return float64(e.Feed(tr.Phase)) / 128
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%-24s %8s %8s %8s\n", "term", "white", "black", "total")
+	var white, black Eval
+	for _, t := range tr.Terms {
+		total := t.Value[White]
+		total.Merge(neg(t.Value[Black]))
+		fmt.Fprintf(buf, "%-24s %8.0f %8.0f %8.0f\n", t.Name, cp(t.Value[White]), cp(t.Value[Black]), cp(total))
+		white.Merge(t.Value[White])
+		black.Merge(t.Value[Black])
+	}
+	total := white
+	total.Merge(neg(black))
+	fmt.Fprintf(buf, "%-24s %8.0f %8.0f %8.0f\n", "total", cp(white), cp(black), cp(total))
+	if tr.Variant == VARIANT_Standard {
+		fmt.Fprintf(buf, "phase %d\n", tr.Phase)
+	}
+	fmt.Fprintf(buf, "score %.0f (White's POV)\n", float64(tr.Score)/128)
+	return buf.String()
+}
+
+// neg returns -e.
+func neg(e Eval) Eval {
+	e.Neg()
+	return e
+}
@@ -9,19 +9,62 @@ const (
 
 	msHash          = iota // return hash move
 	msGenViolent           // generate violent moves
-	msReturnViolent        // return violent moves in order
+	msReturnViolent        // return good violent moves in order, defer bad captures
 	msGenKiller            // generate killer moves
 	msReturnKiller         // return killer moves  in order
 	msGenRest              // generate remaining moves
 	msReturnRest           // return remaining moves in order
+	msGenBad               // generate the deferred bad captures
+	msReturnBad            // return bad captures in order
 	msDone                 // all moves returned
 )
 
+const (
+	// counterMoveBonus is the ordering bonus of the counter move.
+	counterMoveBonus = 1 << 13
+	// maxContinuation bounds the continuation history scores.
+	maxContinuation = 1 << 12
+)
+
 var (
 	// mvvlva values based on one pawn = 10.
 	mvvlvaBonus = [...]int16{0, 10, 40, 45, 68, 145, 256}
 )
 
+// counterTable stores for each previous move (piece, to square)
+// the quiet move that caused the last beta cutoff.
+// https://chessprogramming.wikispaces.com/Countermove+Heuristic
+type counterTable [PieceArraySize][SquareArraySize]Move
+
+// continuationTable scores a quiet move (piece, to square) given a
+// previous move (piece, to square). The same table is used for
+// the moves played one and two plies before.
+type continuationTable [PieceArraySize][SquareArraySize][PieceArraySize][SquareArraySize]int16
+
+// update adds bonus to the score of m following prev.
+// The score is decayed so it stays within ±maxContinuation.
+func (ct *continuationTable) update(prev, m Move, bonus int32) {
+	if prev == NullMove {
+		return
+	}
+	h := &ct[prev.Piece()][prev.To()][m.Piece()][m.To()]
+	v := int32(*h)
+	if bonus >= 0 {
+		v += bonus - v*bonus/maxContinuation
+	} else {
+		v += bonus + v*bonus/maxContinuation
+	}
+	*h = int16(v)
+}
+
+// get returns the score of m following prev.
+func (ct *continuationTable) get(prev, m Move) int16 {
+	if prev == NullMove {
+		return 0
+	}
+	return ct[prev.Piece()][prev.To()][m.Piece()][m.To()]
+}
+
 // mvvlva computes Most Valuable Victim / Least Valuable Aggressor
 // https://chessprogramming.wikispaces.com/MVV-LVA
 func mvvlva(m Move) int16 {
@@ -34,6 +77,7 @@ func mvvlva(m Move) int16 {
 type moveStack struct {
 	moves []Move  // list of moves
 	order []int16 // weight of each move for comparison
+	bad   []Move  // losing captures (SEE < 0) deferred after the quiet moves
 
 	kind   int     // violent or all
 	state  int     // current generation state
@@ -45,12 +89,20 @@ type moveStack struct {
 type stack struct {
 	position *Position
 	moves    []moveStack
+
+	// counter and continuation are kept between searches.
+	counter      *counterTable
+	continuation *continuationTable
 }
 
 // Reset clear the stack for a new position.
 func (st *stack) Reset(pos *Position) {
 	st.position = pos
 	st.moves = st.moves[:0]
+	if st.counter == nil {
+		st.counter = &counterTable{}
+		st.continuation = &continuationTable{}
+	}
 }
 
 // get returns the moveStack for current ply.
@@ -60,6 +112,7 @@ func (st *stack) get() *moveStack {
 		st.moves = append(st.moves, moveStack{
 			moves: make([]Move, 0, 4),
 			order: make([]int16, 0, 4),
+			bad:   make([]Move, 0, 4),
 		})
 	}
 	return &st.moves[st.position.Ply]
@@ -70,6 +123,7 @@ func (st *stack) GenerateMoves(kind int, hash Move) {
 	ms := st.get()
 	ms.moves = ms.moves[:0] // clear the array, but keep the backing memory
 	ms.order = ms.order[:0]
+	ms.bad = ms.bad[:0]
 	ms.kind = kind
 	ms.state = msHash
 	ms.hash = hash
@@ -91,6 +145,50 @@ func (st *stack) generateMoves(kind int) {
 	}
 }
 
+// generateQuietMoves generates the tactical and quiet moves and
+// orders them by counter move and continuation history.
+func (st *stack) generateQuietMoves() {
+	ms := &st.moves[st.position.Ply]
+	if len(ms.moves) != 0 || len(ms.order) != 0 {
+		panic("expected no moves")
+	}
+	if ms.kind&(Tactical|Quiet) == 0 {
+		return
+	}
+	st.position.GenerateMoves(ms.kind&(Tactical|Quiet), &ms.moves)
+
+	prev1, prev2 := st.position.moveBack(1), st.position.moveBack(2)
+	counter := NullMove
+	if prev1 != NullMove {
+		counter = st.counter[prev1.Piece()][prev1.To()]
+	}
+
+	sorted := true
+	for _, m := range ms.moves {
+		order := st.continuation.get(prev1, m) + st.continuation.get(prev2, m)
+		if m == counter {
+			order += counterMoveBonus
+		}
+		if n := len(ms.order); n > 0 && ms.order[n-1] > order {
+			sorted = false
+		}
+		ms.order = append(ms.order, order)
+	}
+
+	if !sorted {
+		// Stable insertion sort. popFront takes moves from the back so
+		// moves with equal scores are returned in reverse generation order.
+		for i := 1; i < len(ms.moves); i++ {
+			m, o := ms.moves[i], ms.order[i]
+			j := i
+			for ; j > 0 && ms.order[j-1] > o; j-- {
+				ms.moves[j], ms.order[j] = ms.moves[j-1], ms.order[j-1]
+			}
+			ms.moves[j], ms.order[j] = m, o
+		}
+	}
+}
+
 // moveBest moves best move to front.
 func (st *stack) moveBest() {
 	ms := &st.moves[st.position.Ply]
@@ -128,9 +226,11 @@ func (st *stack) popFront() Move {
 // Returns NullMove if there are no moves.
 // Moves are generated in several phases:
 //	first the hash move,
-//      then the violent moves,
+//      then the winning and equal violent moves,
 //      then the killer moves,
-//      then the tactical and quiet moves.
+//      then the tactical and quiet moves ordered by counter move
+//           and continuation history,
+//      then the losing captures.
 func (st *stack) PopMove() Move {
 	ms := &st.moves[st.position.Ply]
 	for {
@@ -157,13 +257,16 @@ func (st *stack) PopMove() Move {
 				if ms.kind&(Tactical|Quiet) == 0 {
 					// Optimization: skip remaining steps if no Tactical or Quiet moves
 					// were requested (e.g. in quiescence search).
-					ms.state = msDone
+					ms.state = msGenBad
 				} else {
 					ms.state = msGenKiller
 				}
 			} else if m == ms.hash {
 				break
-			} else if m != NullMove {
+			} else if seeSignBefore(st.position, m) {
+				// Losing captures are tried after the quiet moves.
+				ms.bad = append(ms.bad, m)
+			} else {
 				return m
 			}
 
@@ -187,20 +290,36 @@ func (st *stack) PopMove() Move {
 				return m
 			}
 
-		// Return the quiet and tactical moves in the order they were generated.
+		// Return the quiet and tactical moves ordered by history.
+		// Moves with equal scores are returned in reverse generation order.
 		case msGenRest:
 			ms.state = msReturnRest
-			st.generateMoves(Tactical | Quiet)
+			st.generateQuietMoves()
 
 		case msReturnRest:
 			if m := st.popFront(); m == NullMove {
-				ms.state = msDone
+				ms.state = msGenBad
 			} else if m == ms.hash || st.IsKiller(m) {
 				break
 			} else {
 				return m
 			}
 
+		// Return the losing captures in the order they were deferred.
+		case msGenBad:
+			ms.state = msReturnBad
+			for i := len(ms.bad) - 1; i >= 0; i-- {
+				ms.moves = append(ms.moves, ms.bad[i])
+				ms.order = append(ms.order, 0)
+			}
+
+		case msReturnBad:
+			if m := st.popFront(); m == NullMove {
+				ms.state = msDone
+			} else {
+				return m
+			}
+
 		case msDone:
 			// Just in case another move is requested.
 			return NullMove
@@ -237,3 +356,25 @@ func (st *stack) SaveKiller(m Move) {
 		}
 	}
 }
+
+// SaveCutoff updates the counter move and the continuation history
+// after the quiet move m caused a beta cutoff at depth.
+// quiets are the quiet moves searched before m without a cutoff.
+func (st *stack) SaveCutoff(m Move, quiets []Move, depth int32) {
+	if !m.IsQuiet() {
+		return
+	}
+
+	prev1, prev2 := st.position.moveBack(1), st.position.moveBack(2)
+	if prev1 != NullMove {
+		st.counter[prev1.Piece()][prev1.To()] = m
+	}
+
+	bonus := min(depth*depth, 400)
+	st.continuation.update(prev1, m, bonus)
+	st.continuation.update(prev2, m, bonus)
+	for _, q := range quiets {
+		st.continuation.update(prev1, q, -bonus)
+		st.continuation.update(prev2, q, -bonus)
+	}
+}
@@ -12,6 +12,7 @@ var (
 	errorBadDisambiguation = fmt.Errorf("bad disambiguation")
 	errorBadPromotion      = fmt.Errorf("only pawns on the last rank can be promoted")
 	errorNoSuchMove        = fmt.Errorf("no such move")
+	errorIllegalMove       = fmt.Errorf("illegal move")
 
 	// Maps runes to figures.
 	symbolToFigure = map[rune]Figure{
@@ -40,7 +41,9 @@ var (
 //   + (check) and # (checkmate) is ignored.
 //   e.p. (enpassant) is ignored
 //
-// TODO: verify that the returned move is legal.
+// Only legal moves are returned so a piece which is pinned, or
+// in Racing Kings would give check, is not considered when
+// resolving ambiguities.
 func (pos *Position) SANToMove(s string) (Move, error) {
 	moveType := Normal
 	rank, file := -1, -1 // from
@@ -59,27 +62,19 @@ func (pos *Position) SANToMove(s string) (Move, error) {
 	}
 
 	if s[b:e] == "o-o" || s[b:e] == "O-O" { // king side castling
+		oo, _ := castlingRights(pos.SideToMove)
+		m := pos.castlingMove(oo)
 		moveType = Castling
-		if pos.SideToMove == White {
-			rank, file = SquareE1.Rank(), SquareE1.File()
-			to = SquareG1
-			target = WhiteKing
-		} else {
-			rank, file = SquareE8.Rank(), SquareE8.File()
-			to = SquareG8
-			target = BlackKing
-		}
+		rank, file = m.From().Rank(), m.From().File()
+		to = m.To()
+		target = m.Target()
 	} else if s[b:e] == "o-o-o" || s[b:e] == "O-O-O" { // queen side castling
+		_, ooo := castlingRights(pos.SideToMove)
+		m := pos.castlingMove(ooo)
 		moveType = Castling
-		if pos.SideToMove == White {
-			rank, file = SquareE1.Rank(), SquareE1.File()
-			to = SquareC1
-			target = WhiteKing
-		} else {
-			rank, file = SquareE8.Rank(), SquareE8.File()
-			to = SquareC8
-			target = BlackKing
-		}
+		rank, file = m.From().Rank(), m.From().File()
+		to = m.To()
+		target = m.Target()
 	} else { // all other moves
 		// Get the piece.
 		if ('a' <= s[b] && s[b] <= 'h') || s[b] == 'x' {
@@ -165,6 +160,8 @@ func (pos *Position) SANToMove(s string) (Move, error) {
 	} else {
 		pos.GenerateFigureMoves(target.Figure(), All, &moves)
 	}
+	lf := newLegalFilter(pos)
+	illegal := false
 	for _, pm := range moves {
 		if pm.MoveType() != moveType || pm.Capture() != capture {
 			continue
@@ -178,13 +175,80 @@ func (pos *Position) SANToMove(s string) (Move, error) {
 		if file != -1 && pm.From().File() != file {
 			continue
 		}
+		if !lf.isLegal(pm) {
+			illegal = true
+			continue
+		}
 		return pm, nil
 	}
+	if illegal {
+		return Move(0), errorIllegalMove
+	}
 	return Move(0), errorNoSuchMove
 }
 
+// MoveToSAN converts the legal move m to standard algebraic notation.
+// The move is disambiguated among the legal moves of the position.
+// + and # are appended for checks and checkmates. In Racing Kings
+// checks are illegal so a king reaching the 8th rank is not a check.
+func (pos *Position) MoveToSAN(m Move) string {
+	var s string
+	fig := m.Piece().Figure()
+	switch {
+	case m.MoveType() == Castling && m.To().File() > m.From().File():
+		s = "O-O"
+	case m.MoveType() == Castling:
+		s = "O-O-O"
+	case fig == Pawn:
+		if m.Capture() != NoPiece {
+			s = m.From().String()[:1] + "x"
+		}
+		s += m.To().String()
+		if m.MoveType() == Promotion {
+			s += "=" + figureToSymbol[m.Target().Figure()]
+		}
+	default:
+		s = figureToSymbol[fig]
+		// Disambiguate by file, then by rank, then by both.
+		sameFile, sameRank, ambiguous := false, false, false
+		for _, o := range pos.GetLegalMoves(GET_ALL) {
+			if o != m && o.Piece() == m.Piece() && o.To() == m.To() {
+				ambiguous = true
+				sameFile = sameFile || o.From().File() == m.From().File()
+				sameRank = sameRank || o.From().Rank() == m.From().Rank()
+			}
+		}
+		if ambiguous {
+			from := m.From().String()
+			if !sameFile {
+				s += from[:1]
+			} else if !sameRank {
+				s += from[1:]
+			} else {
+				s += from
+			}
+		}
+		if m.Capture() != NoPiece {
+			s += "x"
+		}
+		s += m.To().String()
+	}
+
+	pos.DoMove(m)
+	if pos.ByPiece(pos.SideToMove, King) != 0 && pos.IsCheckedLocal(pos.SideToMove) {
+		if pos.HasLegalMoves() {
+			s += "+"
+		} else {
+			s += "#"
+		}
+	}
+	pos.UndoMove()
+	return s
+}
+
 // UCIToMove parses a move given in UCI format.
-// s can be "a2a4" or "h7h8Q" for pawn promotion.
+// s can be "a2a4" or "h7h8Q" for pawn promotion. Castling can be written
+// as "e1g1" or, as required for Chess960, as the king capturing its rook "e1h1".
 func (pos *Position) UCIToMove(s string) (Move, error) {
 	if len(s) < 4 {
 		return NullMove, fmt.Errorf("%s is too short", s)
@@ -208,10 +272,19 @@ func (pos *Position) UCIToMove(s string) (Move, error) {
 		moveType = Enpassant
 		capt = ColorFigure(pos.SideToMove.Opposite(), Pawn)
 	}
-	if pi == WhiteKing && from == SquareE1 && (to == SquareC1 || to == SquareG1) {
+	if pi.Figure() == King && capt == ColorFigure(pi.Color(), Rook) {
+		// In Chess960 castling is written as the king capturing its own rook.
 		moveType = Castling
-	}
-	if pi == BlackKing && from == SquareE8 && (to == SquareC8 || to == SquareG8) {
+		capt = NoPiece
+	} else if pi == WhiteKing && from == SquareE1 && (to == SquareC1 || to == SquareG1) ||
+		pi == BlackKing && from == SquareE8 && (to == SquareC8 || to == SquareG8) {
+		// Otherwise the king moves two squares towards the rook.
+		oo, ooo := castlingRights(pi.Color())
+		if to.File() > from.File() {
+			to = pos.castling.rook[oo]
+		} else {
+			to = pos.castling.rook[ooo]
+		}
 		moveType = Castling
 	}
 	if pi.Figure() == Pawn && (to.Rank() == 0 || to.Rank() == 7) {
@@ -230,5 +303,8 @@ func (pos *Position) UCIToMove(s string) (Move, error) {
 	if !pos.IsPseudoLegal(move) {
 		return NullMove, fmt.Errorf("%s is not a valid move", s)
 	}
+	if !pos.IsLegal(move) {
+		return NullMove, fmt.Errorf("%s is not a legal move", s)
+	}
 	return move, nil
 }
@@ -15,6 +15,14 @@ var (
 	zobristCastle    [CastleArraySize]uint64
 	zobristColor     [ColorArraySize]uint64
 
+	// zobristVariant is mixed into the Zobrist key so positions of different
+	// variants don't share hash table entries. Standard chess uses zero so
+	// its keys are the Polyglot keys.
+	zobristVariant = [len(START_FENS)]uint64{
+		VARIANT_Standard:     0,
+		VARIANT_Racing_Kings: 0x6A09E667F3BCC908,
+	}
+
 	// Polyglot random numbers.
 	// http://hgm.nubati.net/book_format.html
 	// piece     (offset:   0, length: 768)
@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -21,17 +24,44 @@ var (
 	// FENStartPos is the FEN string of the starting position.
 	FENStartPos = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 
-	// Which castle rights are lost when pieces are moved.
-	lostCastleRights [64]Castle
+	// standardCastling are the castling rules of standard chess.
+	standardCastling castlingRules
 )
 
 func init() {
-	lostCastleRights[SquareA1] = WhiteOOO
-	lostCastleRights[SquareE1] = WhiteOOO | WhiteOO
-	lostCastleRights[SquareH1] = WhiteOO
-	lostCastleRights[SquareA8] = BlackOOO
-	lostCastleRights[SquareE8] = BlackOOO | BlackOO
-	lostCastleRights[SquareH8] = BlackOO
+	standardCastling.king[White] = SquareE1
+	standardCastling.king[Black] = SquareE8
+	standardCastling.rook[WhiteOO] = SquareH1
+	standardCastling.rook[WhiteOOO] = SquareA1
+	standardCastling.rook[BlackOO] = SquareH8
+	standardCastling.rook[BlackOOO] = SquareA8
+	standardCastling.update()
+}
+
+// castlingRules stores the start squares of the kings and of the castling
+// rooks. In Chess960 they depend on the start position.
+type castlingRules struct {
+	king [ColorArraySize]Square  // start square of the king
+	rook [CastleArraySize]Square // start square of the rook, indexed by castling right
+	lost [SquareArraySize]Castle // castling rights lost when a piece moves from or to a square
+}
+
+// update recomputes which castling rights are lost when pieces are moved.
+func (cr *castlingRules) update() {
+	cr.lost = [SquareArraySize]Castle{}
+	for _, c := range [...]Castle{WhiteOO, WhiteOOO, BlackOO, BlackOOO} {
+		cr.lost[cr.rook[c]] |= c
+	}
+	cr.lost[cr.king[White]] |= WhiteOO | WhiteOOO
+	cr.lost[cr.king[Black]] |= BlackOO | BlackOOO
+}
+
+// castlingRights returns the king and queen side castling rights of col.
+func castlingRights(col Color) (oo, ooo Castle) {
+	if col == White {
+		return WhiteOO, WhiteOOO
+	}
+	return BlackOO, BlackOOO
 }
 
 type state struct {
@@ -43,6 +73,13 @@ type state struct {
 }
 
 // Position represents the chess board and keeps track of the move history.
+//
+// A Position is not safe for concurrent use. Besides DoMove and UndoMove,
+// methods which look read only may execute moves and take them back,
+// among them GetLegalMoves, HasLegalMoves, GenerateLegalMoves, IsLegal,
+// MoveToSAN, SANToMove, UCIToMove and Result. Searching with an Engine
+// changes the position until the search ends. Use Clone to give each
+// goroutine its own copy.
 type Position struct {
 	ByFigure   [FigureArraySize]Bitboard // bitboards of square occupancy by figure.
 	ByColor    [ColorArraySize]Bitboard  // bitboards of square occupancy by color.
@@ -52,6 +89,16 @@ type Position struct {
 	fullmoveCounter int     // fullmove counter, incremented after black move
 	states          []state // a state for each Ply
 	curr            *state  // current state
+
+	// Start squares of the kings and of the castling rooks.
+	// They are set by ParseCastlingAbility for Chess960 positions.
+	castling castlingRules
+
+	// Incremental evaluation accumulators updated by Put and Remove.
+	// Counts and rank sums are kept instead of values so that the
+	// Racing Kings piece values can be changed while running.
+	numPieces [ColorArraySize][FigureArraySize]int32 // number of pieces by color and figure
+	sumRanks  [ColorArraySize][FigureArraySize]int32 // sum of ranks of pieces by color and figure
 }
 
 ///////////////////////////////////////////////////
@@ -64,37 +111,29 @@ func (pos *Position) IsOnBaseRank(color Color) bool {
 	return false
 }
 
-func (pos *Position) PrintBoard() {
-	/*fmt.Printf("  0123456701234567012345670123456701234567012345670123456701234567\n")
-	for j:=0 ; j<FigureArraySize; j++ {
-		fmt.Printf("%d %064b\n",j,pos.ByFigure[j])
-	}*/
-	var mask uint64=1 << uint(SquareArraySize-1)
-	buff:=""
-	for i:=0; i<SquareArraySize; i++ {
-		found:=false
-		var j=0
-		for ; j<FigureArraySize; j++ {
-			if uint64(pos.ByFigure[j]) & mask != 0 {
-				found=true
-				break
-			}
-		}
-		if(!found){
-			buff="☐"+buff
-		} else {
-			if uint64(pos.ByColor[Black]) & mask != 0 {
-				buff=pieceToSymbolStr(Piece(2*j))+buff
-			} else {
-				buff=pieceToSymbolStr(Piece(2*j+1))+buff
-			}
-		}
-		if (i%8) == 7 {
-			fmt.Printf("%s\n",buff)
-			buff=""
+// canReachBaseRank returns true if color's king can step to the 8th rank
+// next move. The test is approximate because it ignores that a king move
+// discovering a check is illegal in Racing Kings.
+func (pos *Position) canReachBaseRank(color Color) bool {
+	king := pos.ByPiece(color, King)
+	if king == 0 || king.AsSquare().Rank() != 6 {
+		return false
+	}
+	them := color.Opposite()
+	all := (pos.ByColor[White] | pos.ByColor[Black]) &^ king
+	for bb := bbKingAttack[king.AsSquare()] & BbRank8 &^ pos.ByColor[color]; bb != 0; {
+		if pos.attackers(bb.Pop(), them, all) == 0 {
+			return true
 		}
-		mask=mask >> 1
 	}
+	return false
+}
+
+func (pos *Position) PrintBoard() {
+	pos.Render(os.Stdout, RenderOptions{
+		Unicode:  USE_UNICODE_SYMBOLS,
+		GoalRank: Variant == VARIANT_Racing_Kings,
+	})
 	fmt.Printf("evalw %d evalb %d eval %d bw %v bb %v\n",
 		EvaluateSideRk(pos, White),EvaluateSideRk(pos, Black),Evaluate(pos),
 		pos.IsOnBaseRank(White),pos.IsOnBaseRank(Black))
@@ -106,11 +145,22 @@ func NewPosition() *Position {
 	pos := &Position{
 		fullmoveCounter: 1,
 		states:          make([]state, 1, 4),
+		castling:        standardCastling,
 	}
 	pos.curr = &pos.states[pos.Ply]
 	return pos
 }
 
+// Clone returns a deep copy of pos which can be used independently,
+// e.g. from another goroutine. The history is copied too so the copy
+// detects repetitions and can undo the moves played before it was made.
+func (pos *Position) Clone() *Position {
+	clone := *pos
+	clone.states = append(make([]state, 0, cap(pos.states)), pos.states...)
+	clone.curr = &clone.states[len(clone.states)-1]
+	return &clone
+}
+
 // String returns position in FEN format.
 // For table format use PrettyPrint.
 func (pos *Position) String() string {
@@ -187,10 +237,79 @@ func (pos *Position) LastMove() Move {
 	return pos.curr.Move
 }
 
+// moveBack returns the move played n plies ago, 1 being the last move.
+// Returns NullMove if there is no such move.
+func (pos *Position) moveBack(n int) Move {
+	if n < 1 || n > len(pos.states) {
+		return NullMove
+	}
+	return pos.states[len(pos.states)-n].Move
+}
+
+// History returns the moves played since the root position,
+// i.e. the position parsed from FEN or set up with Put.
+func (pos *Position) History() []Move {
+	moves := make([]Move, 0, len(pos.states)-1)
+	for _, st := range pos.states[1:] {
+		moves = append(moves, st.Move)
+	}
+	return moves
+}
+
+// RootFEN returns the root position in FEN format.
+func (pos *Position) RootFEN() string {
+	return pos.rewind(len(pos.states) - 1).String()
+}
+
+// Replay returns a copy of the position after the first n moves
+// of the history. Replay(0) returns the root position. pos is not changed.
+func (pos *Position) Replay(n int) (*Position, error) {
+	if n < 0 || n >= len(pos.states) {
+		return nil, fmt.Errorf("cannot replay %d moves, expected at most %d", n, len(pos.states)-1)
+	}
+	return pos.rewind(len(pos.states) - 1 - n), nil
+}
+
+// rewind returns a copy of the position with the last n moves taken back.
+func (pos *Position) rewind(n int) *Position {
+	clone := pos.Clone()
+	for i := 0; i < n; i++ {
+		clone.UndoMove()
+	}
+	return clone
+}
+
+// HistoryUCI returns the moves played since the root position in UCI notation.
+func (pos *Position) HistoryUCI() []string {
+	var moves []string
+	for _, m := range pos.History() {
+		moves = append(moves, m.UCI())
+	}
+	return moves
+}
+
+// HistorySAN returns the moves played since the root position in SAN.
+func (pos *Position) HistorySAN() []string {
+	root := pos.rewind(len(pos.states) - 1)
+	var moves []string
+	for _, m := range pos.History() {
+		moves = append(moves, root.MoveToSAN(m))
+		root.DoMove(m)
+	}
+	return moves
+}
+
 // Zobrist returns the zobrist key of the position.
-// The returned value is equal to polyglot book key
-// (http://hgm.nubati.net/book_format.html).
+// The key depends on the variant so the same position in different
+// variants has different keys. For standard chess the returned value
+// is equal to PolyglotKey.
 func (pos *Position) Zobrist() uint64 {
+	return pos.curr.Zobrist ^ zobristVariant[Variant]
+}
+
+// PolyglotKey returns the polyglot book key of the position
+// (http://hgm.nubati.net/book_format.html) regardless of the variant.
+func (pos *Position) PolyglotKey() uint64 {
 	return pos.curr.Zobrist
 }
 
@@ -210,8 +329,16 @@ func (pos *Position) HasNonPawns(col Color) bool {
 func (pos *Position) IsPseudoLegal(m Move) bool {
 	if m == NullMove ||
 		m.SideToMove() != pos.SideToMove ||
-		pos.Get(m.From()) != m.Piece() ||
-		pos.Get(m.CaptureSquare()) != m.Capture() ||
+		pos.Get(m.From()) != m.Piece() {
+		return false
+	}
+	if m.MoveType() == Castling {
+		// The king captures its own rook so the capture is not checked.
+		oo, ooo := castlingRights(pos.SideToMove)
+		return m == pos.castlingMove(oo) && pos.canCastle(oo) ||
+			m == pos.castlingMove(ooo) && pos.canCastle(ooo)
+	}
+	if pos.Get(m.CaptureSquare()) != m.Capture() ||
 		m.Piece().Color() == m.Capture().Color() {
 		return false
 	}
@@ -252,55 +379,10 @@ func (pos *Position) IsPseudoLegal(m Move) bool {
 	case Queen:
 		return to&QueenMobility(sq, all) != 0
 	case King:
-		if m.MoveType() == Normal {
-			return to&bbKingAttack[sq] != 0
-		}
-
-		// m.MoveType() == Castling
-		if m.SideToMove() == White && m.To() == SquareG1 {
-			if pos.CastlingAbility()&WhiteOO == 0 ||
-				!pos.IsEmpty(SquareF1) || !pos.IsEmpty(SquareG1) {
-				return false
-			}
-		}
-		if m.SideToMove() == White && m.To() == SquareC1 {
-			if pos.CastlingAbility()&WhiteOOO == 0 ||
-				!pos.IsEmpty(SquareB1) ||
-				!pos.IsEmpty(SquareC1) ||
-				!pos.IsEmpty(SquareD1) {
-				return false
-			}
-		}
-		if m.SideToMove() == Black && m.To() == SquareG8 {
-			if pos.CastlingAbility()&BlackOO == 0 ||
-				!pos.IsEmpty(SquareF8) ||
-				!pos.IsEmpty(SquareG8) {
-				return false
-			}
-		}
-		if m.SideToMove() == Black && m.To() == SquareC8 {
-			if pos.CastlingAbility()&BlackOOO == 0 ||
-				!pos.IsEmpty(SquareB8) ||
-				!pos.IsEmpty(SquareC8) ||
-				!pos.IsEmpty(SquareD8) {
-				return false
-			}
-		}
-		rook, start, end := CastlingRook(m.To())
-		if pos.Get(start) != rook {
-			return false
-		}
-		them := m.SideToMove().Opposite()
-		if pos.GetAttacker(m.From(), them) != NoFigure ||
-			pos.GetAttacker(end, them) != NoFigure ||
-			pos.GetAttacker(m.To(), them) != NoFigure {
-			return false
-		}
+		return to&bbKingAttack[sq] != 0
 	default:
 		panic("unreachable")
 	}
-
-	return true
 }
 
 // Verify check the validity of the position.
@@ -341,6 +423,22 @@ func (pos *Position) Verify() error {
 		}
 	}
 
+	// Verifies that the incremental evaluation accumulators are in sync.
+	for col := ColorMinValue; col <= ColorMaxValue; col++ {
+		for fig := FigureMinValue; fig <= FigureMaxValue; fig++ {
+			num, sum := int32(0), int32(0)
+			for bb := pos.ByPiece(col, fig); bb != 0; {
+				sq := bb.Pop()
+				num++
+				sum += int32(sq.Rank())
+			}
+			if pos.numPieces[col][fig] != num || pos.sumRanks[col][fig] != sum {
+				return fmt.Errorf("Expected %d %v with rank sum %d, got %d with rank sum %d",
+					num, ColorFigure(col, fig), sum, pos.numPieces[col][fig], pos.sumRanks[col][fig])
+			}
+		}
+	}
+
 	// Verifies that en passant square is empty.
 	if sq := pos.curr.EnpassantSquare[0]; sq != SquareA1 && !pos.IsEmpty(sq) {
 		return fmt.Errorf("Expected empty en passant square %v, got %v", sq, pos.Get(sq))
@@ -413,6 +511,8 @@ func (pos *Position) Put(sq Square, pi Piece) {
 		bb := sq.Bitboard()
 		pos.ByColor[pi.Color()] |= bb
 		pos.ByFigure[pi.Figure()] |= bb
+		pos.numPieces[pi.Color()][pi.Figure()]++
+		pos.sumRanks[pi.Color()][pi.Figure()] += int32(sq.Rank())
 	}
 }
 
@@ -424,6 +524,8 @@ func (pos *Position) Remove(sq Square, pi Piece) {
 		bb := ^sq.Bitboard()
 		pos.ByColor[pi.Color()] &= bb
 		pos.ByFigure[pi.Figure()] &= bb
+		pos.numPieces[pi.Color()][pi.Figure()]--
+		pos.sumRanks[pi.Color()][pi.Figure()] -= int32(sq.Rank())
 	}
 }
 
@@ -491,24 +593,15 @@ func (pos *Position) HasLegalMoves() bool {
 }
 
 // generate all legal moves
+// The moves are filtered with a legalFilter instead of executing each of them.
 func (pos *Position) GetLegalMoves(getfirst bool) []Move {
 	var moves []Move
 	var legalMoves=[]Move{}
 	pos.GenerateMoves(All, &moves)
-	us := pos.SideToMove
-	them := us.Opposite()
+	lf := newLegalFilter(pos)
 
 	for _, m := range moves {
-		pos.DoMove(m)
-		checked := pos.IsChecked(us)
-		// In Racing Kings any move that gives local check is also illegal.
-		if Variant == VARIANT_Racing_Kings {
-			checkedThem := pos.IsCheckedLocal(them)
-			checked=checked||checkedThem
-		}
-		pos.UndoMove()
-
-		if !checked {
+		if lf.isLegal(m) {
 			if getfirst {	
 				return []Move{m}
 			} else {
@@ -568,7 +661,7 @@ func (pos *Position) InsufficientMaterial() bool {
 // ThreeFoldRepetition returns whether current position was seen three times already.
 // Returns minimum between 3 and the actual number of repetitions.
 func (pos *Position) ThreeFoldRepetition() int {
-	c, z := 0, pos.Zobrist()
+	c, z := 0, pos.curr.Zobrist
 	for i := 0; i < len(pos.states) && i <= pos.curr.HalfmoveClock; i += 2 {
 		if pos.states[len(pos.states)-1-i].Zobrist == z {
 			if c++; c == 3 {
@@ -622,25 +715,11 @@ func (pos *Position) IsChecked(side Color) bool {
 func (pos *Position) PrettyPrint() {
 	log.Println("zobrist =", pos.Zobrist())
 	log.Println("fen =", pos.String())
-	for r := 7; r >= 0; r-- {
-		line := ""
-		for f := 0; f < 8; f++ {
-			sq := RankFile(r, f)
-			if pos.IsEnpassantSquare(sq) {
-				line += ","
-			} else {
-				line += string(pieceToSymbol[pos.Get(sq)])
-			}
-		}
-		if r == 7 && pos.SideToMove == Black {
-			line += " *"
-		}
-		if r == 0 && pos.SideToMove == White {
-			line += " *"
-		}
+	buf := &bytes.Buffer{}
+	pos.Render(buf, RenderOptions{Coordinates: true, Markers: true})
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
 		log.Println(line)
 	}
-
 }
 
 // DoMove executes a legal move.
@@ -652,7 +731,8 @@ func (pos *Position) DoMove(move Move) {
 	// Update castling rights.
 	pi := move.Piece()
 	if pi != NoPiece { // nullmove cannot change castling ability
-		pos.SetCastlingAbility(curr.CastlingAbility &^ lostCastleRights[move.From()] &^ lostCastleRights[move.To()])
+		lost := pos.castling.lost[move.From()] | pos.castling.lost[move.To()]
+		pos.SetCastlingAbility(curr.CastlingAbility &^ lost)
 	}
 	// update fullmove counter.
 	if pos.SideToMove == Black {
@@ -669,17 +749,21 @@ func (pos *Position) DoMove(move Move) {
 	} else if pos.EnpassantSquare() != SquareA1 {
 		pos.SetEnpassantSquare(SquareA1)
 	}
-	// Move rook on castling.
+	// Update the pieces on the chess board.
 	if move.MoveType() == Castling {
-		rook, start, end := CastlingRook(move.To())
-		pos.Remove(start, rook)
-		pos.Put(end, rook)
+		// Both pieces are removed first because in Chess960
+		// the king and the rook can end on each other's square.
+		rook := ColorFigure(pi.Color(), Rook)
+		kingEnd, rookEnd := castlingSquares(move)
+		pos.Remove(move.From(), pi)
+		pos.Remove(move.To(), rook)
+		pos.Put(kingEnd, pi)
+		pos.Put(rookEnd, rook)
+	} else {
+		pos.Remove(move.From(), pi)
+		pos.Remove(move.CaptureSquare(), move.Capture())
+		pos.Put(move.To(), move.Target())
 	}
-
-	// Update the pieces on the chess board.
-	pos.Remove(move.From(), pi)
-	pos.Remove(move.CaptureSquare(), move.Capture())
-	pos.Put(move.To(), move.Target())
 	pos.SetSideToMove(pos.SideToMove.Opposite())
 }
 
@@ -693,15 +777,17 @@ func (pos *Position) UndoMove() {
 
 	// Modify the chess board.
 	pi := move.Piece()
-	pos.Put(move.From(), pi)
-	pos.Remove(move.To(), move.Target())
-	pos.Put(move.CaptureSquare(), move.Capture())
-
-	// Move rook on castling.
 	if move.MoveType() == Castling {
-		rook, start, end := CastlingRook(move.To())
-		pos.Put(start, rook)
-		pos.Remove(end, rook)
+		rook := ColorFigure(pi.Color(), Rook)
+		kingEnd, rookEnd := castlingSquares(move)
+		pos.Remove(kingEnd, pi)
+		pos.Remove(rookEnd, rook)
+		pos.Put(move.From(), pi)
+		pos.Put(move.To(), rook)
+	} else {
+		pos.Put(move.From(), pi)
+		pos.Remove(move.To(), move.Target())
+		pos.Put(move.CaptureSquare(), move.Capture())
 	}
 
 	if pos.SideToMove == Black {
@@ -935,52 +1021,58 @@ func (pos *Position) genKingCastles(kind int, moves *[]Move) {
 		return
 	}
 
-	rank := pos.SideToMove.KingHomeRank()
-	oo, ooo := WhiteOO, WhiteOOO
-	if pos.SideToMove == Black {
-		oo, ooo = BlackOO, BlackOOO
+	oo, ooo := castlingRights(pos.SideToMove)
+	if pos.canCastle(oo) {
+		*moves = append(*moves, pos.castlingMove(oo))
 	}
+	if pos.canCastle(ooo) {
+		*moves = append(*moves, pos.castlingMove(ooo))
+	}
+}
 
-	// Castle king side.
-	if pos.curr.CastlingAbility&oo != 0 {
-		r5 := RankFile(rank, 5)
-		r6 := RankFile(rank, 6)
-		if !pos.IsEmpty(r5) || !pos.IsEmpty(r6) {
-			goto EndCastleOO
-		}
-
-		r4 := RankFile(rank, 4)
-		other := pos.SideToMove.Opposite()
-		if pos.GetAttacker(r4, other) != NoFigure ||
-			pos.GetAttacker(r5, other) != NoFigure ||
-			pos.GetAttacker(r6, other) != NoFigure {
-			goto EndCastleOO
-		}
+// castlingMove returns the castling move for the castling right c.
+// The move is encoded as the king capturing its own rook.
+func (pos *Position) castlingMove(c Castle) Move {
+	col := White
+	if c&(BlackOO|BlackOOO) != 0 {
+		col = Black
+	}
+	king := ColorFigure(col, King)
+	return MakeMove(Castling, pos.castling.king[col], pos.castling.rook[c], NoPiece, king)
+}
 
-		*moves = append(*moves, MakeMove(Castling, r4, r6, NoPiece, ColorFigure(pos.SideToMove, King)))
+// canCastle returns true if the side to move can castle using the right c.
+// The king and the rook must be on their start squares, the squares they
+// move over must be empty, and the king cannot cross or end on an attacked
+// square, nor castle out of check.
+func (pos *Position) canCastle(c Castle) bool {
+	if pos.curr.CastlingAbility&c == 0 {
+		return false
+	}
+	us := pos.SideToMove
+	m := pos.castlingMove(c)
+	king, rook := m.From(), m.To()
+	if m.SideToMove() != us || pos.Get(king) != m.Piece() || pos.Get(rook) != ColorFigure(us, Rook) {
+		return false
 	}
-EndCastleOO:
 
-	// Castle queen side.
-	if pos.curr.CastlingAbility&ooo != 0 {
-		r3 := RankFile(rank, 3)
-		r2 := RankFile(rank, 2)
-		r1 := RankFile(rank, 1)
-		if !pos.IsEmpty(r3) || !pos.IsEmpty(r2) || !pos.IsEmpty(r1) {
-			goto EndCastleOOO
-		}
+	kingEnd, rookEnd := castlingSquares(m)
+	kingPath := bbBetween[king][kingEnd] | kingEnd.Bitboard()
+	rookPath := bbBetween[rook][rookEnd] | rookEnd.Bitboard()
+	others := (pos.ByColor[White] | pos.ByColor[Black]) &^ king.Bitboard() &^ rook.Bitboard()
+	if (kingPath|rookPath)&others != 0 {
+		return false
+	}
 
-		r4 := RankFile(rank, 4)
-		other := pos.SideToMove.Opposite()
-		if pos.GetAttacker(r4, other) != NoFigure ||
-			pos.GetAttacker(r3, other) != NoFigure ||
-			pos.GetAttacker(r2, other) != NoFigure {
-			goto EndCastleOOO
+	// The rook is removed because in Chess960 it can shield
+	// the king's end square from a rook or a queen.
+	them := us.Opposite()
+	for bb := kingPath | king.Bitboard(); bb != 0; {
+		if pos.attackers(bb.Pop(), them, others) != 0 {
+			return false
 		}
-
-		*moves = append(*moves, MakeMove(Castling, r4, r2, NoPiece, ColorFigure(pos.SideToMove, King)))
 	}
-EndCastleOOO:
+	return true
 }
 
 // GetAttacker returns the smallest figure of color them that attacks sq.
@@ -26,8 +26,9 @@ type pvEntry struct {
 // During alpha-beta search entries that are on principal variation,
 // are exact nodes, i.e. their score lies exactly between alpha and beta.
 type pvTable struct {
-	table []pvEntry
-	timer uint32
+	table     []pvEntry
+	timer     uint32
+	hashTable *HashTable // looked up for lost entries, GlobalHashTable if nil
 }
 
 // newPvTable returns a new pvTable.
@@ -72,7 +73,7 @@ func (pv *pvTable) Put(pos *Position, move Move) {
 	}
 }
 
-// TODO: Lookup move in transposition table if none is available.
+// get returns the move stored in the table for pos or NullMove.
 func (pv *pvTable) get(pos *Position) Move {
 	entry1 := &pv.table[uint32(pos.Zobrist())&pvTableMask]
 	entry2 := &pv.table[uint32(pos.Zobrist()>>32)&pvTableMask]
@@ -92,18 +93,45 @@ func (pv *pvTable) get(pos *Position) Move {
 	return entry.move
 }
 
+// move returns the move on principal variation for pos.
+//
+// If the table lost the entry for pos then the move is looked up in
+// the transposition table. Only moves from exact and failed high entries are used.
+// Returns NullMove if no legal move is found.
+func (pv *pvTable) move(pos *Position) Move {
+	lf := newLegalFilter(pos)
+	if m := pv.get(pos); isValidMove(pos, &lf, m) {
+		return m
+	}
+	ht := pv.hashTable
+	if ht == nil {
+		ht = GlobalHashTable
+	}
+	if entry := ht.get(pos); entry.kind == exact || entry.kind == failedHigh {
+		if isValidMove(pos, &lf, entry.move) {
+			return entry.move
+		}
+	}
+	return NullMove
+}
+
 // Get returns the principal variation.
+// The moves are executed on pos and taken back before returning.
 func (pv *pvTable) Get(pos *Position) []Move {
 	seen := make(map[uint64]bool)
 	var moves []Move
 
 	// Extract the moves by following the position.
-	next := pv.get(pos)
-	for next != NullMove && !seen[pos.Zobrist()] {
+	next := pv.move(pos)
+	for next != NullMove && !seen[pos.Zobrist()] && len(moves) < maxPVLength {
 		seen[pos.Zobrist()] = true
 		moves = append(moves, next)
 		pos.DoMove(next)
-		next = pv.get(pos)
+		if pos.staticResult(3).Outcome != Ongoing {
+			// The game has ended, e.g. a king reached the 8th rank.
+			break
+		}
+		next = pv.move(pos)
 	}
 
 	// Undo all moves, so we get back to the initial state.
@@ -112,3 +140,68 @@ func (pv *pvTable) Get(pos *Position) []Move {
 	}
 	return moves
 }
+
+// isValidMove returns true if m is a legal move for the position of lf.
+func isValidMove(pos *Position, lf *legalFilter, m Move) bool {
+	return m != NullMove && pos.IsPseudoLegal(m) && lf.isLegal(m)
+}
+
+// addPonderMove makes sure that pv has a ponder move if the
+// opponent has any legal move after the best move.
+// If the principal variation has a single move then the first
+// legal move of the opponent is used.
+func addPonderMove(pos *Position, pv []Move) []Move {
+	if len(pv) != 1 {
+		return pv
+	}
+	pos.DoMove(pv[0])
+	if pos.staticResult(3).Outcome == Ongoing {
+		if moves := pos.GetLegalMoves(GET_FIRST); len(moves) != 0 {
+			pv = append(pv, moves[0])
+		}
+	}
+	pos.UndoMove()
+	return pv
+}
+
+// maxPVLength is the maximum length of a principal variation.
+const maxPVLength = 128
+
+// triangularPV stores the principal variation in a triangular array.
+//
+// Row ply holds the principal variation found at ply. When a move
+// improves α the row is replaced by the move followed by row ply+1.
+// Unlike pvTable, the principal variation is cut at hash table hits.
+//
+// https://chessprogramming.wikispaces.com/Triangular+PV-Table
+type triangularPV struct {
+	moves  [maxPVLength][maxPVLength]Move
+	length [maxPVLength]int
+}
+
+// clear empties the principal variation at ply.
+// Should be called when entering a new node.
+func (tp *triangularPV) clear(ply int32) {
+	if ply < maxPVLength {
+		tp.length[ply] = 0
+	}
+}
+
+// update sets the principal variation at ply to m
+// followed by the principal variation at ply+1.
+func (tp *triangularPV) update(ply int32, m Move) {
+	if ply >= maxPVLength {
+		return
+	}
+	tp.moves[ply][0] = m
+	n := 0
+	if ply+1 < maxPVLength {
+		n = copy(tp.moves[ply][1:], tp.moves[ply+1][:tp.length[ply+1]])
+	}
+	tp.length[ply] = n + 1
+}
+
+// Get returns the principal variation at root.
+func (tp *triangularPV) Get() []Move {
+	return append([]Move(nil), tp.moves[0][:tp.length[0]]...)
+}
@@ -0,0 +1,102 @@
+// render.go draws the board as text.
+
+package engine
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	ansiLastMove = "\x1b[43m" // yellow background
+	ansiCheck    = "\x1b[41m" // red background
+	ansiReset    = "\x1b[0m"
+)
+
+// RenderOptions controls how Render draws the board.
+type RenderOptions struct {
+	Unicode     bool // draw pieces using Unicode chess symbols instead of letters
+	Coordinates bool // label the files and the ranks
+	Flip        bool // draw the board from Black's side
+	Color       bool // highlight the last move and the checked king using ANSI colors
+	GoalRank    bool // mark the 8th rank which the kings race to in Racing Kings
+	Markers     bool // mark the side to move with * and the en passant square with ,
+}
+
+// Render writes the board to w, one rank per line.
+func (pos *Position) Render(w io.Writer, opts RenderOptions) error {
+	// Squares to highlight.
+	var lastMove, checked Bitboard
+	if opts.Color {
+		if m := pos.LastMove(); m != NullMove {
+			lastMove = m.From().Bitboard() | m.To().Bitboard()
+		}
+		us := pos.SideToMove
+		if pos.ByPiece(us, King) != 0 && pos.IsCheckedLocal(us) {
+			checked = pos.ByPiece(us, King)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < 8; i++ {
+		r := 7 - i
+		if opts.Flip {
+			r = i
+		}
+		if opts.Coordinates {
+			buf.WriteByte(itoa[r+1])
+			buf.WriteByte(' ')
+		}
+		for j := 0; j < 8; j++ {
+			f := j
+			if opts.Flip {
+				f = 7 - j
+			}
+			if j > 0 {
+				buf.WriteByte(' ')
+			}
+
+			sq := RankFile(r, f)
+			highlight := true
+			if checked.Has(sq) {
+				buf.WriteString(ansiCheck)
+			} else if lastMove.Has(sq) {
+				buf.WriteString(ansiLastMove)
+			} else {
+				highlight = false
+			}
+			pi := pos.Get(sq)
+			if opts.Markers && pos.IsEnpassantSquare(sq) {
+				buf.WriteByte(',')
+			} else if pi == NoPiece && opts.Unicode {
+				buf.WriteRune('·')
+			} else if opts.Unicode {
+				buf.WriteRune(pieceToSymbolU[pi])
+			} else {
+				buf.WriteByte(pieceToSymbol[pi])
+			}
+			if highlight {
+				buf.WriteString(ansiReset)
+			}
+		}
+		// The side to move is marked next to its first rank.
+		if opts.Markers && (r == 0 && pos.SideToMove == White || r == 7 && pos.SideToMove == Black) {
+			buf.WriteString(" *")
+		}
+		if opts.GoalRank && r == 7 {
+			buf.WriteString("  <- goal")
+		}
+		buf.WriteByte('\n')
+	}
+
+	if opts.Coordinates {
+		files := "a b c d e f g h"
+		if opts.Flip {
+			files = "h g f e d c b a"
+		}
+		buf.WriteString("  " + files + "\n")
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
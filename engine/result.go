@@ -0,0 +1,154 @@
+// result.go decides whether the game is over and who won.
+
+package engine
+
+import "fmt"
+
+// Outcome is the outcome of a game.
+type Outcome int
+
+const (
+	Ongoing   Outcome = iota // the game has not ended
+	WhiteWins                // White won the game
+	BlackWins                // Black won the game
+	Draw                     // the game ended in a draw
+)
+
+var outcomeToString = [...]string{"*", "1-0", "0-1", "1/2-1/2"}
+
+// String returns the outcome in PGN notation.
+func (o Outcome) String() string {
+	return outcomeToString[o]
+}
+
+// Reason is why a game has ended.
+type Reason int
+
+const (
+	NoReason         Reason = iota // the game has not ended
+	Checkmate                      // the side to move is checkmated
+	Stalemate                      // the side to move has no legal moves and is not in check
+	KingReachedRank8               // a king won the race to the 8th rank
+	BothKingsOnRank8               // both kings reached the 8th rank
+	Repetition                     // the same position was seen three times
+	FiftyMoves                     // fifty moves without a capture or a pawn move
+	NoMatingMaterial               // neither side can mate
+	KingCaptured                   // a king is missing
+)
+
+var reasonToString = [...]string{
+	"",
+	"checkmate",
+	"stalemate",
+	"king reached the 8th rank",
+	"both kings reached the 8th rank",
+	"threefold repetition",
+	"fifty move rule",
+	"insufficient material",
+	"king captured",
+}
+
+// String returns a description of the reason.
+func (r Reason) String() string {
+	return reasonToString[r]
+}
+
+// Result is the state of a game.
+type Result struct {
+	Outcome Outcome
+	Reason  Reason
+}
+
+// String returns the result in PGN notation followed by the reason, if any.
+func (r Result) String() string {
+	if r.Reason == NoReason {
+		return r.Outcome.String()
+	}
+	return fmt.Sprintf("%v {%v}", r.Outcome, r.Reason)
+}
+
+// wins returns the result of col winning for reason.
+func wins(col Color, reason Reason) Result {
+	if col == White {
+		return Result{WhiteWins, reason}
+	}
+	return Result{BlackWins, reason}
+}
+
+// Result returns the result of the game in the current position.
+//
+// In Racing Kings the first king to reach the 8th rank wins,
+// but if White reaches it first Black has one more move
+// to reach it too, in which case the game is a draw.
+//
+// This function is expensive because it generates the legal moves.
+func (pos *Position) Result() Result {
+	if r := pos.staticResult(3); r.Outcome != Ongoing {
+		return r
+	}
+	if pos.HasLegalMoves() {
+		return Result{Ongoing, NoReason}
+	}
+
+	us := pos.SideToMove
+	if Variant == VARIANT_Racing_Kings && pos.IsOnBaseRank(us.Opposite()) {
+		// White reached the 8th rank and Black cannot follow.
+		return wins(us.Opposite(), KingReachedRank8)
+	}
+	if pos.IsChecked(us) {
+		return wins(us.Opposite(), Checkmate)
+	}
+	return Result{Draw, Stalemate}
+}
+
+// staticResult returns the result of the game without looking at the legal moves,
+// i.e. checkmate and stalemate are not detected.
+// repetitions is the number of times the position has to be seen to be a draw.
+func (pos *Position) staticResult(repetitions int) Result {
+	// Trivial cases when kings are missing.
+	white, black := pos.ByPiece(White, King) != 0, pos.ByPiece(Black, King) != 0
+	if !white && !black {
+		return Result{Draw, KingCaptured}
+	}
+	if !white {
+		return wins(Black, KingCaptured)
+	}
+	if !black {
+		return wins(White, KingCaptured)
+	}
+
+	if Variant == VARIANT_Racing_Kings {
+		if r := pos.raceResult(); r.Outcome != Ongoing {
+			return r
+		}
+	} else if pos.InsufficientMaterial() {
+		// Neither side cannot mate.
+		return Result{Draw, NoMatingMaterial}
+	}
+
+	// Fifty full moves without a capture or a pawn move.
+	if pos.FiftyMoveRule() {
+		return Result{Draw, FiftyMoves}
+	}
+	if pos.ThreeFoldRepetition() >= repetitions {
+		return Result{Draw, Repetition}
+	}
+	return Result{Ongoing, NoReason}
+}
+
+// raceResult returns the result of the race to the 8th rank in Racing Kings.
+// Black moves second so it wins as soon as its king reaches the 8th rank.
+// White's king reaching it first wins only after Black's reply.
+func (pos *Position) raceResult() Result {
+	white, black := pos.IsOnBaseRank(White), pos.IsOnBaseRank(Black)
+	if white && black {
+		return Result{Draw, BothKingsOnRank8}
+	}
+	if black {
+		return wins(Black, KingReachedRank8)
+	}
+	if white && pos.SideToMove == White {
+		return wins(White, KingReachedRank8)
+	}
+	return Result{Ongoing, NoReason}
+}
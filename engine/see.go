@@ -6,7 +6,26 @@ package engine
 // The values are fixed to approximatively the figure bonus in mid game.
 var seeBonus = [FigureArraySize]int32{0, 55, 325, 341, 454, 1110, 20000}
 
+///////////////////////////////////////////////////
+// NEW
+// seeBonusRk returns the piece bonuses for Racing Kings.
+// The values follow RK_PIECE_VALUES which can be changed by the user.
+func seeBonusRk(fig Figure) int32 {
+	if fig == King {
+		return seeBonus[King]
+	}
+	return RK_PIECE_VALUES[fig]
+}
+///////////////////////////////////////////////////
+
 func seeScore(m Move) int32 {
+	///////////////////////////////////////////////////
+	// NEW
+	if Variant == VARIANT_Racing_Kings {
+		// There are no pawns, so no promotions either.
+		return seeBonusRk(m.Capture().Figure())
+	}
+	///////////////////////////////////////////////////
 	score := seeBonus[m.Capture().Figure()]
 	if m.MoveType() == Promotion {
 		score -= seeBonus[Pawn]
@@ -24,6 +43,15 @@ func seeSign(pos *Position, m Move) bool {
 	return see(pos, m) < 0
 }
 
+// seeSignBefore returns true if seeBefore(m) < 0.
+func seeSignBefore(pos *Position, m Move) bool {
+	if m.Piece().Figure() <= m.Capture().Figure() {
+		// Even if m.Piece() is captured, we are still positive.
+		return false
+	}
+	return seeBefore(pos, m) < 0
+}
+
 // see returns the static exchange evaluation for m, where is
 // the last move executed.
 //
@@ -35,17 +63,33 @@ func seeSign(pos *Position, m Move) bool {
 // on some fixed values for figures, different from the ones
 // defined in material.go.
 func see(pos *Position, m Move) int32 {
+	var occ [ColorArraySize]Bitboard
+	occ[White] = pos.ByColor[White]
+	occ[Black] = pos.ByColor[Black]
+	return seeSwap(pos, m, pos.SideToMove, occ)
+}
+
+// seeBefore is like see, but m is a move that was not executed yet.
+// Used for ordering the moves before searching them.
+func seeBefore(pos *Position, m Move) int32 {
 	us := pos.SideToMove
+	them := us.Opposite()
+
+	// Occupancy tables as if m was executed.
+	var occ [ColorArraySize]Bitboard
+	occ[us] = pos.ByColor[us]&^m.From().Bitboard() | m.To().Bitboard()
+	occ[them] = pos.ByColor[them] &^ m.CaptureSquare().Bitboard()
+	return seeSwap(pos, m, them, occ)
+}
+
+// seeSwap implements the swap algorithm for m given the occupancy
+// tables after m was executed. us is the side to recapture.
+func seeSwap(pos *Position, m Move, us Color, occ [ColorArraySize]Bitboard) int32 {
 	sq := m.To()
 	bb := sq.Bitboard()
 	target := m.Target() // piece in position
 	bb27 := bb &^ (BbRank1 | BbRank8)
 	bb18 := bb & (BbRank1 | BbRank8)
-
-	// Occupancy tables as if moves are executed.
-	var occ [ColorArraySize]Bitboard
-	occ[White] = pos.ByColor[White]
-	occ[Black] = pos.ByColor[Black]
 	all := occ[White] | occ[Black]
 
 	// Adjust score for move.
@@ -62,6 +106,18 @@ func see(pos *Position, m Move) int32 {
 		ours := occ[us]
 		mt := Normal
 
+		///////////////////////////////////////////////////
+		// NEW
+		if Variant == VARIANT_Racing_Kings {
+			// Captures giving check are illegal so the
+			// attackers must be tested one by one.
+			if fig, att = seeAttackerRk(pos, sq, us, occ); fig == NoFigure {
+				break
+			}
+			goto makeMove
+		}
+		///////////////////////////////////////////////////
+
 		// Pawn attacks.
 		pawn = Backward(us, West(bb27)|East(bb27))
 		if att = pawn & ours & pos.ByFigure[Pawn]; att != 0 {
@@ -137,3 +193,75 @@ func see(pos *Position, m Move) int32 {
 	}
 	return gain[0]
 }
+
+///////////////////////////////////////////////////
+// NEW
+// seeAttackerRk returns the smallest attacker of color us that can
+// legally capture on sq in Racing Kings, i.e. without giving check.
+// Returns NoFigure if there is no such attacker.
+func seeAttackerRk(pos *Position, sq Square, us Color, occ [ColorArraySize]Bitboard) (Figure, Bitboard) {
+	all := occ[White] | occ[Black]
+	ours := occ[us] &^ sq.Bitboard()
+	them := us.Opposite()
+	king := pos.ByFigure[King] & occ[them]
+
+	for fig := Knight; fig <= King; fig++ {
+		var att Bitboard
+		switch fig {
+		case Knight:
+			att = bbKnightAttack[sq]
+		case Bishop:
+			att = BishopMobility(sq, all)
+		case Rook:
+			att = RookMobility(sq, all)
+		case Queen:
+			att = QueenMobility(sq, all)
+		case King:
+			att = bbKingAttack[sq]
+		}
+
+		for att &= ours & pos.ByFigure[fig]; att != 0; {
+			from := att.LSB()
+			att &^= from
+			if king == 0 || !seeGivesCheckRk(pos, fig, sq, us, all&^from, ours&^from, king.AsSquare()) {
+				return fig, from
+			}
+		}
+	}
+	return NoFigure, BbEmpty
+}
+
+// seeGivesCheckRk returns true if the figure fig of color us standing
+// on sq attacks the king on ksq given the occupancy after the capture.
+// ours are the remaining pieces of us, excluding the capturing piece.
+func seeGivesCheckRk(pos *Position, fig Figure, sq Square, us Color, all, ours Bitboard, ksq Square) bool {
+	k := ksq.Bitboard()
+	switch fig {
+	case Knight:
+		if bbKnightAttack[sq]&k != 0 {
+			return true
+		}
+	case Bishop:
+		if BishopMobility(sq, all)&k != 0 {
+			return true
+		}
+	case Rook:
+		if RookMobility(sq, all)&k != 0 {
+			return true
+		}
+	case Queen:
+		if QueenMobility(sq, all)&k != 0 {
+			return true
+		}
+	}
+	// Discovered checks by the sliders left behind.
+	all |= sq.Bitboard()
+	if BishopMobility(ksq, all)&ours&(pos.ByFigure[Bishop]|pos.ByFigure[Queen]) != 0 {
+		return true
+	}
+	if RookMobility(ksq, all)&ours&(pos.ByFigure[Rook]|pos.ByFigure[Queen]) != 0 {
+		return true
+	}
+	return false
+}
+///////////////////////////////////////////////////
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// collectUpdates reads all updates until the channel is closed.
+func collectUpdates(t *testing.T, updates <-chan AnalysisUpdate, timeout time.Duration) []AnalysisUpdate {
+	var all []AnalysisUpdate
+	deadline := time.After(timeout)
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return all
+			}
+			all = append(all, u)
+		case <-deadline:
+			t.Fatalf("analysis did not finish in %v", timeout)
+		}
+	}
+}
+
+func TestAnalyzeDepth(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+	log := &NulLogger{}
+	eng := NewEngine(pos, log, Options{HashTable: NewHashTable(1)})
+	all := collectUpdates(t, eng.Analyze(context.Background(), Limits{Depth: 4}), 30*time.Second)
+
+	if len(all) < 2 {
+		t.Fatalf("expected progress and a result, got %d updates", len(all))
+	}
+	for i, u := range all[:len(all)-1] {
+		if u.Final {
+			t.Errorf("#%d unexpected final update", i)
+		}
+		if i > 0 && u.Depth <= all[i-1].Depth {
+			t.Errorf("#%d expected increasing depth, got %d after %d", i, u.Depth, all[i-1].Depth)
+		}
+		if u.Depth > 0 && len(u.PV) == 0 {
+			t.Errorf("#%d expected a principal variation at depth %d", i, u.Depth)
+		}
+	}
+
+	final := all[len(all)-1]
+	if !final.Final || final.Depth != 4 || len(final.PV) == 0 {
+		t.Fatalf("expected a final result at depth 4, got %+v", final)
+	}
+	if final.Nodes < all[len(all)-2].Nodes {
+		t.Errorf("expected at least %d nodes, got %d", all[len(all)-2].Nodes, final.Nodes)
+	}
+	for _, m := range final.PV {
+		if !pos.IsPseudoLegal(m) {
+			t.Fatalf("invalid move %v in pv %v", m, final.PV)
+		}
+		pos.DoMove(m)
+	}
+
+	if eng.Log != log {
+		t.Errorf("expected the engine's logger to be restored")
+	}
+}
+
+func TestAnalyzeCancel(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+	eng := NewEngine(pos, nil, Options{HashTable: NewHashTable(1)})
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := eng.Analyze(ctx, Limits{})
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	all := collectUpdates(t, updates, 10*time.Second)
+	if len(all) == 0 || !all[len(all)-1].Final || len(all[len(all)-1].PV) == 0 {
+		t.Errorf("expected a final result, got %+v", all)
+	}
+}
+
+func TestAnalyzeMate(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	// Black wins by moving the king to the 8th rank.
+	pos, _ := PositionFromFEN("8/1k6/8/8/8/8/8/6K1 b - - 0 1")
+	eng := NewEngine(pos, nil, Options{HashTable: NewHashTable(1)})
+	all := collectUpdates(t, eng.Analyze(context.Background(), Limits{MoveTime: 100 * time.Millisecond}), 10*time.Second)
+	if final := all[len(all)-1]; final.Mate != 1 || final.PV[0].To().Rank() != 7 {
+		t.Errorf("expected mate in 1, got %+v", final)
+	}
+
+	// The game has ended.
+	pos, _ = PositionFromFEN("1k6/8/8/8/8/8/8/6K1 w - - 0 1")
+	eng = NewEngine(pos, nil, Options{HashTable: NewHashTable(1)})
+	all = collectUpdates(t, eng.Analyze(context.Background(), Limits{Depth: 3}), 10*time.Second)
+	if final := all[len(all)-1]; !final.Final || len(final.PV) != 0 {
+		t.Errorf("expected no move, got %+v", final)
+	}
+}
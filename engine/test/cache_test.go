@@ -42,3 +42,22 @@ func TestCachePutGet(t *testing.T) {
 		t.Errorf("entry in the cache, expecting a miss")
 	}
 }
+
+// Tests that each engine has its own evaluation cache
+// which gives the same scores as no cache.
+func TestEngineEvalCache(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	eng1 := NewEngine(pos, nil, Options{})
+	eng2 := NewEngine(pos, nil, Options{})
+	if eng1.evalCache == eng2.evalCache {
+		t.Errorf("expected engines to have different evaluation caches")
+	}
+	for i := 0; i < 2; i++ { // the second time the cache is used
+		if got, expected := evaluate(pos, eng1.evalCache), Evaluate(pos); got != expected {
+			t.Errorf("#%d: expected score %d, got %d", i, expected, got)
+		}
+	}
+}
@@ -15,6 +15,43 @@ func TestPositionFromFENAndBack(t *testing.T) {
 	}
 }
 
+func TestCastlingAbilityChess960(t *testing.T) {
+	data := []struct {
+		fen      string
+		castling string // formatted castling ability, empty for invalid fens
+	}{
+		// Shredder-FEN gives the files of the rooks.
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", "KQkq"},
+		{"b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9", "KQ"},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w HAha - 0 1", "KQkq"},
+		// X-FEN uses the file if the castling rook is not the outermost one.
+		{"4k3/8/8/8/8/8/8/RR2K1RR w GB - 0 1", "GB"},
+		{"4k3/8/8/8/8/8/8/RR2K1RR w KQ - 0 1", "KQ"},
+		{"rr2k1rr/8/8/8/8/8/8/4K3 w gb - 0 1", "gb"},
+		// No rook on the given file.
+		{"4k3/8/8/8/8/8/8/R3K2R w C - 0 1", ""},
+		// The king is not between the rooks.
+		{"4k3/8/8/8/8/8/8/R3K2R w E - 0 1", ""},
+		// No king on the home rank.
+		{"4k3/8/8/8/8/8/4K3/R6R w A - 0 1", ""},
+	}
+
+	for _, d := range data {
+		pos, err := PositionFromFEN(d.fen)
+		if d.castling == "" {
+			if err == nil {
+				t.Errorf("%s: expected error", d.fen)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", d.fen, err)
+		} else if castling := FormatCastlingAbility(pos); castling != d.castling {
+			t.Errorf("%s: expected castling ability %s, got %s", d.fen, d.castling, castling)
+		}
+	}
+}
+
 func BenchmarkPositionFromFEN(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, d := range testFENs {
@@ -0,0 +1,103 @@
+package engine
+
+import "testing"
+
+// testHashPositions returns n different positions.
+func testHashPositions(n int) []*Position {
+	var positions []*Position
+	start, _ := PositionFromFEN(FENStartPos)
+	var moves []Move
+	start.GenerateMoves(All, &moves)
+	for _, m := range moves[:n] {
+		pos, _ := PositionFromFEN(FENStartPos)
+		pos.DoMove(m)
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+func TestHashTableBucket(t *testing.T) {
+	// The table has a single bucket.
+	ht := NewHashTable(0)
+	if ht.Size() != hashBucketSize {
+		t.Fatalf("expected %d entries, got %d", hashBucketSize, ht.Size())
+	}
+
+	positions := testHashPositions(hashBucketSize + 1)
+	for i, pos := range positions[:hashBucketSize] {
+		ht.put(pos, hashEntry{kind: exact, depth: int8(i + 1), score: int32(i)})
+	}
+	for i, pos := range positions[:hashBucketSize] {
+		if e := ht.get(pos); e.kind != exact || e.score != int32(i) {
+			t.Errorf("#%d expected score %d, got %v", i, i, e)
+		}
+	}
+
+	// Replacing an entry of the same position doesn't evict other entries.
+	ht.put(positions[2], hashEntry{kind: failedLow, depth: 1, score: 20})
+	if e := ht.get(positions[2]); e.kind != failedLow || e.score != 20 {
+		t.Errorf("expected updated entry, got %v", e)
+	}
+
+	// The shallowest entry is replaced.
+	ht.put(positions[hashBucketSize], hashEntry{kind: exact, depth: 5})
+	if e := ht.get(positions[0]); e.kind != noEntry {
+		t.Errorf("expected entry with depth 1 to be replaced, got %v", e)
+	}
+	for i, pos := range positions[1:] {
+		if e := ht.get(pos); e.kind == noEntry {
+			t.Errorf("#%d expected entry, got none", i+1)
+		}
+	}
+}
+
+func TestHashTableAging(t *testing.T) {
+	ht := NewHashTable(0)
+	positions := testHashPositions(hashBucketSize + 1)
+
+	// Deep entries from an old search.
+	for _, pos := range positions[:hashBucketSize-1] {
+		ht.put(pos, hashEntry{kind: exact, depth: 10})
+	}
+	ht.NewSearch()
+	ht.NewSearch()
+
+	// A shallow entry from the current search.
+	ht.put(positions[hashBucketSize-1], hashEntry{kind: exact, depth: 1})
+	// The first position is used by the current search, too.
+	ht.get(positions[0])
+
+	ht.put(positions[hashBucketSize], hashEntry{kind: exact, depth: 1})
+	if e := ht.get(positions[1]); e.kind != noEntry {
+		t.Errorf("expected the old entry to be replaced, got %v", e)
+	}
+	for _, i := range []int{0, 2, 3, 4} {
+		if e := ht.get(positions[i]); e.kind == noEntry {
+			t.Errorf("#%d expected entry, got none", i)
+		}
+	}
+}
+
+func TestHashfull(t *testing.T) {
+	ht := NewHashTable(1)
+	if h := ht.Hashfull(); h != 0 {
+		t.Errorf("expected empty table, got hashfull %d", h)
+	}
+
+	for i := range ht.table {
+		for j := range ht.table[i] {
+			if j%2 == 0 {
+				ht.table[i][j] = hashEntry{kind: exact, generation: ht.generation}
+			}
+		}
+	}
+	if h := ht.Hashfull(); h != 500 {
+		t.Errorf("expected half full table, got hashfull %d", h)
+	}
+
+	// Entries from previous searches are not counted.
+	ht.NewSearch()
+	if h := ht.Hashfull(); h != 0 {
+		t.Errorf("expected no entries from the current search, got hashfull %d", h)
+	}
+}
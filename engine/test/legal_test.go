@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var (
+	// Racing Kings positions for the legal move generator.
+	testRacingKingsFENs = []string{
+		"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1",
+		"8/8/8/8/8/3k4/r1bnNB1K/qr3BRQ w - - 0 1",
+		"8/8/8/8/2k5/8/r1bnN2K/qr3BRQ b - - 0 1",
+		"5K2/8/2k5/8/8/8/r1bn4/qr3BRQ b - - 0 1",
+		"k7/8/5K2/8/8/8/r1bnNBR1/qrbnNBRQ w - - 0 1",
+		"8/8/8/3k4/2n5/1K6/3N1B2/1r2Q3 w - - 0 1",
+		// Both kings are checked.
+		"8/8/4k3/8/2n5/1K6/3N1B2/1r2Q3 w - - 0 1",
+	}
+)
+
+// legalMovesByDoMove is the reference implementation of legal move generation.
+// It executes every pseudo-legal move and tests whether the king was left in check
+// and, in Racing Kings, whether the enemy king was checked.
+func legalMovesByDoMove(pos *Position) []Move {
+	var moves, legal []Move
+	us := pos.SideToMove
+	if Variant == VARIANT_Racing_Kings {
+		// The game is over unless White reached the 8th rank
+		// and Black has one more move to reach it, too.
+		white, black := pos.IsOnBaseRank(White), pos.IsOnBaseRank(Black)
+		if black || white && us == White {
+			return nil
+		}
+	}
+
+	pos.GenerateMoves(All, &moves)
+	for _, m := range moves {
+		pos.DoMove(m)
+		checked := pos.IsChecked(us)
+		if Variant == VARIANT_Racing_Kings {
+			checked = checked || pos.IsCheckedLocal(us.Opposite())
+		}
+		pos.UndoMove()
+		if !checked {
+			legal = append(legal, m)
+		}
+	}
+	return legal
+}
+
+func perftByDoMove(pos *Position, depth int) uint64 {
+	if depth == 0 {
+		return 1
+	}
+	var nodes uint64
+	for _, m := range legalMovesByDoMove(pos) {
+		pos.DoMove(m)
+		nodes += perftByDoMove(pos, depth-1)
+		pos.UndoMove()
+	}
+	return nodes
+}
+
+func perftLegal(pos *Position, depth int) uint64 {
+	if depth == 0 {
+		return 1
+	}
+	var moves []Move
+	pos.GenerateLegalMoves(All, &moves)
+	var nodes uint64
+	for _, m := range moves {
+		pos.DoMove(m)
+		nodes += perftLegal(pos, depth-1)
+		pos.UndoMove()
+	}
+	return nodes
+}
+
+func testLegalMoves(t *testing.T, pos *Position) {
+	expected := make(map[Move]bool)
+	for _, m := range legalMovesByDoMove(pos) {
+		expected[m] = true
+	}
+	var actual []Move
+	pos.GenerateLegalMoves(All, &actual)
+	for _, m := range actual {
+		if !expected[m] {
+			t.Errorf("%v: move %v is not legal", pos, m)
+		}
+		delete(expected, m)
+	}
+	for m := range expected {
+		t.Errorf("%v: legal move %v was not generated", pos, m)
+	}
+}
+
+func TestGenerateLegalMovesStandard(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	for _, fen := range testFENs {
+		pos, _ := PositionFromFEN(fen)
+		testLegalMoves(t, pos)
+
+		// Also test the positions one ply later.
+		for _, m := range legalMovesByDoMove(pos) {
+			pos.DoMove(m)
+			testLegalMoves(t, pos)
+			pos.UndoMove()
+		}
+	}
+}
+
+func TestGenerateLegalMovesRacingKings(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	for _, fen := range testRacingKingsFENs {
+		pos, _ := PositionFromFEN(fen)
+		testLegalMoves(t, pos)
+	}
+
+	// Random games reach the rank 8 race positions.
+	r := rand.New(rand.NewSource(1))
+	for game := 0; game < 50; game++ {
+		pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+		for ply := 0; ply < 80; ply++ {
+			testLegalMoves(t, pos)
+			moves := legalMovesByDoMove(pos)
+			if len(moves) == 0 {
+				break
+			}
+			pos.DoMove(moves[r.Intn(len(moves))])
+		}
+	}
+}
+
+// testIsLegal verifies IsLegal for the pseudo-legal moves of pos
+// and for the moves in others which were built for other positions.
+func testIsLegal(t *testing.T, pos *Position, others []Move) {
+	expected := make(map[Move]bool)
+	for _, m := range legalMovesByDoMove(pos) {
+		expected[m] = true
+	}
+	var moves []Move
+	pos.GenerateMoves(All, &moves)
+	for _, m := range append(moves, others...) {
+		if actual := pos.IsLegal(m); actual != expected[m] {
+			t.Errorf("%v: expected IsLegal(%v) = %v, got %v", pos, m, expected[m], actual)
+		}
+	}
+	if pos.IsLegal(NullMove) {
+		t.Errorf("%v: null move is legal", pos)
+	}
+}
+
+func TestIsLegal(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		fens := testFENs
+		if variant == VARIANT_Racing_Kings {
+			fens = testRacingKingsFENs
+		}
+
+		for _, fen := range fens {
+			pos, _ := PositionFromFEN(fen)
+			var moves []Move
+			pos.GenerateMoves(All, &moves)
+			testIsLegal(t, pos, nil)
+
+			// The moves of the previous position are mostly invalid.
+			for _, m := range legalMovesByDoMove(pos) {
+				pos.DoMove(m)
+				testIsLegal(t, pos, moves)
+				pos.UndoMove()
+			}
+		}
+	}
+}
+
+func TestUCIToMoveRejectsIllegalMoves(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	data := []struct {
+		variant int
+		fen     string
+		move    string
+	}{
+		// The rook on e7 checks the king.
+		{VARIANT_Standard, "4k3/4r3/8/8/8/8/8/3QK3 w - - 0 1", "d1d2"},
+		// The knight on e2 is pinned.
+		{VARIANT_Standard, "4k3/4r3/8/8/8/8/4N3/4K3 w - - 0 1", "e2c3"},
+		// Moves which no figure can make.
+		{VARIANT_Standard, FENStartPos, "e2e5"},
+		{VARIANT_Standard, FENStartPos, "g1g3"},
+		// The king cannot step next to the other king.
+		{VARIANT_Standard, "8/8/8/3k4/8/3K4/8/8 w - - 0 1", "d3d4"},
+		// Checks are not allowed in Racing Kings.
+		{VARIANT_Racing_Kings, "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1", "e2c3"},
+		// The race has ended.
+		{VARIANT_Racing_Kings, "1k6/8/8/8/8/8/8/6K1 w - - 0 1", "g1g2"},
+	}
+
+	for i, d := range data {
+		Variant = d.variant
+		pos, _ := PositionFromFEN(d.fen)
+		if m, err := pos.UCIToMove(d.move); err == nil {
+			t.Errorf("#%d %s: expected error for %s, got %v", i, d.fen, d.move, m)
+		}
+	}
+}
+
+func TestSANToMoveIgnoresPinnedPieces(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	// The knight on e2 is pinned so Nc3 is not ambiguous.
+	pos, _ := PositionFromFEN("4k3/4r3/8/8/8/8/4N3/1N2K3 w - - 0 1")
+	m, err := pos.SANToMove("Nc3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.From() != SquareB1 {
+		t.Errorf("expected Nb1-c3, got %v", m)
+	}
+	if _, err := pos.SANToMove("Nd4"); err == nil {
+		t.Errorf("expected error for the pinned knight")
+	}
+}
+
+func TestPerftLegalMatchesDoMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	data := []struct {
+		variant int
+		fen     string
+		depth   int
+		nodes   uint64
+	}{
+		{VARIANT_Standard, FENStartPos, 3, 8902},
+		{VARIANT_Standard, fenKiwipete, 2, 2039},
+		{VARIANT_Standard, fenDuplain, 3, 2812},
+		{VARIANT_Racing_Kings, START_FENS[VARIANT_Racing_Kings], 3, 0},
+		{VARIANT_Racing_Kings, testRacingKingsFENs[1], 3, 0},
+	}
+
+	for i, d := range data {
+		Variant = d.variant
+		pos, _ := PositionFromFEN(d.fen)
+		expected := perftByDoMove(pos, d.depth)
+		if d.nodes != 0 && expected != d.nodes {
+			t.Errorf("#%d expected perft(%d) = %d, got %d with the reference", i, d.depth, d.nodes, expected)
+		}
+		if actual := perftLegal(pos, d.depth); actual != expected {
+			t.Errorf("#%d expected perft(%d) = %d, got %d", i, d.depth, expected, actual)
+		}
+	}
+}
+
+// TestPerftChess960 checks positions from the Chess960 perft suite
+// which exercise castling with the king and the rooks on any file.
+func TestPerftChess960(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	data := []struct {
+		fen   string
+		nodes []uint64 // perft for depth 1, 2, ...
+	}{
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", []uint64{21, 528, 12189, 326672}},
+		{"2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9", []uint64{21, 807, 18002, 667366}},
+		{"b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9", []uint64{20, 479, 10471, 273318}},
+		{"qbbnnrkr/2pp2pp/p7/1p2pp2/8/P3PP2/1PPP1KPP/QBBNNR1R w hf - 0 9", []uint64{22, 593, 13440, 382958}},
+		{"1nbbnrkr/p1p1ppp1/3p4/1p3P1p/3Pq2P/8/PPP1P1P1/QNBBNRKR w HFhf - 0 9", []uint64{28, 1120, 31058, 1171749}},
+		{"1rqbkrbn/1ppppp1p/1n6/p1N3p1/8/2P4P/PP1PPPP1/1RQBKRBN w FBfb - 0 9", []uint64{29, 502, 14569, 287739}},
+		{"rbbqn1kr/pp2p1pp/6n1/2pp1p2/2P4P/P7/BP1PPPP1/R1BQNNKR w HAha - 0 9", []uint64{27, 916, 25798, 890435}},
+	}
+
+	for i, d := range data {
+		pos, err := PositionFromFEN(d.fen)
+		if err != nil {
+			t.Fatalf("#%d %s: %v", i, d.fen, err)
+		}
+		for depth, nodes := range d.nodes {
+			if actual := perftLegal(pos, depth+1); actual != nodes {
+				t.Errorf("#%d %s: expected perft(%d) = %d, got %d", i, d.fen, depth+1, nodes, actual)
+			}
+		}
+		if expected, actual := d.nodes[2], perftByDoMove(pos, 3); actual != expected {
+			t.Errorf("#%d %s: expected perft(3) = %d, got %d with the reference", i, d.fen, expected, actual)
+		}
+	}
+}
+
+func benchmarkPerft(b *testing.B, variant int, fen string, depth int, perft func(*Position, int) uint64) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = variant
+	pos, _ := PositionFromFEN(fen)
+	for i := 0; i < b.N; i++ {
+		perft(pos, depth)
+	}
+}
+
+func BenchmarkPerftLegalRacingKings(b *testing.B) {
+	benchmarkPerft(b, VARIANT_Racing_Kings, START_FENS[VARIANT_Racing_Kings], 4, perftLegal)
+}
+
+func BenchmarkPerftDoMoveRacingKings(b *testing.B) {
+	benchmarkPerft(b, VARIANT_Racing_Kings, START_FENS[VARIANT_Racing_Kings], 4, perftByDoMove)
+}
+
+func BenchmarkPerftLegalKiwipete(b *testing.B) {
+	benchmarkPerft(b, VARIANT_Standard, fenKiwipete, 3, perftLegal)
+}
+
+func BenchmarkPerftDoMoveKiwipete(b *testing.B) {
+	benchmarkPerft(b, VARIANT_Standard, fenKiwipete, 3, perftByDoMove)
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Tests that the incremental Racing Kings evaluation matches
+// the evaluation computed from scratch while moves are played and undone.
+func TestIncrementalEvaluateRk(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	check := func(pos *Position) {
+		for col := ColorMinValue; col <= ColorMaxValue; col++ {
+			if inc, full := EvaluateSideRk(pos, col), evaluateSideRkFull(pos, col); inc != full {
+				t.Fatalf("expected %v eval %d, got %d for %v", col, full, inc, pos)
+			}
+		}
+		if err := pos.Verify(); err != nil {
+			t.Fatalf("%v for %v", err, pos)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for game := 0; game < 20; game++ {
+		pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+		played := 0
+		for ; played < 60; played++ {
+			moves := pos.GetLegalMoves(GET_ALL)
+			if len(moves) == 0 {
+				break
+			}
+			pos.DoMove(moves[r.Intn(len(moves))])
+			check(pos)
+		}
+		for ; played > 0; played-- {
+			pos.UndoMove()
+			check(pos)
+		}
+	}
+}
+
+// Tests that the terms of the trace add up to the evaluation.
+func TestEvaluateTrace(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	check := func(pos *Position, names ...string) {
+		tr := EvaluateTrace(pos)
+		var sum Eval
+		for _, term := range tr.Terms {
+			sum.Merge(term.Value[White])
+			sum.Merge(neg(term.Value[Black]))
+		}
+		if tr.Score != Evaluate(pos) {
+			t.Errorf("%v: expected score %d, got %d", pos, Evaluate(pos), tr.Score)
+		}
+		if Variant == VARIANT_Racing_Kings {
+			if sum.M != tr.Score || sum.E != tr.Score {
+				t.Errorf("%v: expected terms to add up to %d, got %+v", pos, tr.Score, sum)
+			}
+		} else if expected := EvaluatePosition(pos); sum.M != expected.M || sum.E != expected.E {
+			t.Errorf("%v: expected terms to add up to %+v, got %+v", pos, expected, sum)
+		}
+
+		table := tr.String()
+		for _, name := range append(names, "total", "score") {
+			if !strings.Contains(table, name) {
+				t.Errorf("%v: expected %q in\n%s", pos, name, table)
+			}
+		}
+	}
+
+	Variant = VARIANT_Standard
+	for _, fen := range testFENs {
+		pos, _ := PositionFromFEN(fen)
+		check(pos, "material pawn", "passed pawns", "mobility", "king shelter", "phase")
+	}
+
+	Variant = VARIANT_Racing_Kings
+	r := rand.New(rand.NewSource(1))
+	pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+	for ply := 0; ply < 40; ply++ {
+		check(pos, "material knight", "king advance", "knight advance")
+		moves := pos.GetLegalMoves(GET_ALL)
+		if len(moves) == 0 {
+			break
+		}
+		pos.DoMove(moves[r.Intn(len(moves))])
+	}
+}
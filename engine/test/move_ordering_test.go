@@ -12,7 +12,15 @@ func TestOrdersViolentMovesByMVVLVA(t *testing.T) {
 		st.GenerateMoves(Violent, NullMove)
 
 		limit := int16(0x7fff)
+		bad := false
 		for move := st.PopMove(); move != NullMove; move = st.PopMove() {
+			// Losing captures are returned last, again sorted by MVVLVA.
+			if seeSignBefore(pos, move) != bad {
+				if bad {
+					t.Errorf("good capture %v after bad captures", move)
+				}
+				bad, limit = true, int16(0x7fff)
+			}
 			if curr := mvvlva(move); curr > limit {
 				t.Errorf("moves not sorted: %v", move)
 			} else {
@@ -23,6 +31,9 @@ func TestOrdersViolentMovesByMVVLVA(t *testing.T) {
 }
 
 func TestReturnsHashMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	pos, _ := PositionFromFEN(fenKiwipete)
 
 	for i, str := range []string{"f3f5", "e2b5", "a1b1"} {
@@ -67,3 +78,115 @@ func TestReturnsMoves(t *testing.T) {
 		}
 	}
 }
+
+// Tests that moves are returned in stages: hash move, good captures,
+// killers, quiet and tactical moves, bad captures. The set of moves
+// returned must be equal to the set of generated moves.
+func TestReturnsMovesInStages(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		fens := testFENs
+		if variant == VARIANT_Racing_Kings {
+			fens = testRacingKingsFENs
+		}
+
+		for _, fen := range fens {
+			pos, _ := PositionFromFEN(fen)
+
+			var moves, violent []Move
+			pos.GenerateMoves(All, &moves)
+			pos.GenerateMoves(Violent, &violent)
+			isViolent := make(map[Move]bool)
+			for _, m := range violent {
+				isViolent[m] = true
+			}
+
+			st := &stack{}
+			st.Reset(pos)
+			st.GenerateMoves(All, moves[len(moves)/2])
+			for i := len(moves) - 1; i >= 0 && i >= len(moves)-3; i-- {
+				st.SaveKiller(moves[i])
+			}
+
+			stage := func(m Move) int {
+				if m == moves[len(moves)/2] {
+					return 0
+				}
+				if isViolent[m] {
+					if seeSignBefore(pos, m) {
+						return 4
+					}
+					return 1
+				}
+				if st.IsKiller(m) {
+					return 2
+				}
+				return 3
+			}
+
+			count := make(map[Move]int)
+			for _, m := range moves {
+				count[m]++
+			}
+			last := 0
+			for m := st.PopMove(); m != NullMove; m = st.PopMove() {
+				if s := stage(m); s < last {
+					t.Errorf("%v: move %v from stage %d returned after stage %d", fen, m, s, last)
+				} else {
+					last = s
+				}
+				count[m]--
+			}
+			for m, c := range count {
+				if c != 0 {
+					t.Errorf("%v: move %v returned %d times less than generated", fen, m, c)
+				}
+			}
+		}
+	}
+}
+
+// Tests that the counter move and the continuation history
+// order the quiet moves after a beta cutoff.
+func TestOrdersQuietMovesByHistory(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN(FENStartPos)
+	e2e4, _ := pos.UCIToMove("e2e4")
+	pos.DoMove(e2e4)
+
+	g8f6, _ := pos.UCIToMove("g8f6")
+	a7a6, _ := pos.UCIToMove("a7a6")
+	h7h6, _ := pos.UCIToMove("h7h6")
+
+	st := &stack{}
+	st.Reset(pos)
+	st.SaveCutoff(g8f6, []Move{a7a6, h7h6}, 4)
+
+	st.GenerateMoves(All, NullMove)
+	var moves []Move
+	for m := st.PopMove(); m != NullMove; m = st.PopMove() {
+		moves = append(moves, m)
+	}
+
+	if moves[0] != g8f6 {
+		t.Errorf("expected counter move %v first, got %v", g8f6, moves[0])
+	}
+	n := len(moves)
+	if !(moves[n-1] == a7a6 && moves[n-2] == h7h6 || moves[n-1] == h7h6 && moves[n-2] == a7a6) {
+		t.Errorf("expected %v and %v last, got %v", a7a6, h7h6, moves[n-2:])
+	}
+
+	// A different previous move has no counter move.
+	pos.UndoMove()
+	d2d4, _ := pos.UCIToMove("d2d4")
+	pos.DoMove(d2d4)
+	st.Reset(pos)
+	st.GenerateMoves(All, NullMove)
+	if m := st.PopMove(); m == g8f6 {
+		t.Errorf("expected no counter move after %v", d2d4)
+	}
+}
@@ -47,6 +47,9 @@ var (
 )
 
 func TestSANToMovePlay(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	for i, test := range sanMoves {
 		pos, err := PositionFromFEN(test.pos)
 		if err != nil {
@@ -63,3 +66,97 @@ func TestSANToMovePlay(t *testing.T) {
 		}
 	}
 }
+
+func TestMoveToSAN(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	data := []struct {
+		fen, uci, san string
+	}{
+		{FENStartPos, "g1f3", "Nf3"},
+		{FENStartPos, "e2e4", "e4"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", "e4d5", "exd5"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "a1d1", "Rad1"},
+		{"4k3/8/8/8/8/R7/8/R3K3 w - - 0 1", "a1a2", "R1a2"},
+		{"4k3/8/8/8/8/8/8/1N2KN2 w - - 0 1", "b1d2", "Nbd2"},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8q", "b8=Q+"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - 0 2", "d8h4", "Qh4#"},
+	}
+
+	for _, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		m, _ := pos.UCIToMove(d.uci)
+		if san := pos.MoveToSAN(m); san != d.san {
+			t.Errorf("%s: expected %s for %s, got %s", d.fen, d.san, d.uci, san)
+		}
+	}
+
+	// Every legal move can be parsed back.
+	for _, fen := range testFENs {
+		pos, _ := PositionFromFEN(fen)
+		for _, m := range pos.GetLegalMoves(GET_ALL) {
+			san := pos.MoveToSAN(m)
+			if actual, err := pos.SANToMove(san); err != nil || actual != m {
+				t.Errorf("%s: %v converted to %s parsed as %v (%v)", fen, m, san, actual, err)
+			}
+		}
+	}
+}
+
+func TestCastlingNotationChess960(t *testing.T) {
+	defer func(v int, c bool) { Variant, Chess960 = v, c }(Variant, Chess960)
+	Variant = VARIANT_Standard
+
+	data := []struct {
+		chess960 bool
+		fen      string
+		in, out  string // UCI notation
+		san      string
+		after    string
+	}{
+		{false, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "e1g1", "O-O", "r3k2r/8/8/8/8/8/8/R4RK1 b kq - 1 1"},
+		{false, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1a1", "e1c1", "O-O-O", "r3k2r/8/8/8/8/8/8/2KR3R b kq - 1 1"},
+		{true, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1h1", "e1h1", "O-O", "r3k2r/8/8/8/8/8/8/R4RK1 b kq - 1 1"},
+		{true, "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8a8", "e8a8", "O-O-O", "2kr3r/8/8/8/8/8/8/R3K2R w KQ - 1 2"},
+		// The king and the rook swap squares.
+		{true, "4k3/8/8/8/8/8/8/5KR1 w G - 0 1", "f1g1", "f1g1", "O-O", "4k3/8/8/8/8/8/8/5RK1 b - - 1 1"},
+		// Only the rook moves.
+		{true, "4k3/8/8/8/8/8/8/1R4KR w HB - 0 1", "g1h1", "g1h1", "O-O", "4k3/8/8/8/8/8/8/1R3RK1 b - - 1 1"},
+		{true, "4k3/8/8/8/8/8/8/1R4KR w HB - 0 1", "g1b1", "g1b1", "O-O-O", "4k3/8/8/8/8/8/8/2KR3R b - - 1 1"},
+	}
+
+	for i, d := range data {
+		Chess960 = d.chess960
+		pos, _ := PositionFromFEN(d.fen)
+		m, err := pos.UCIToMove(d.in)
+		if err != nil {
+			t.Errorf("#%d %s: cannot parse %s: %v", i, d.fen, d.in, err)
+			continue
+		}
+		if m.MoveType() != Castling {
+			t.Errorf("#%d %s: expected castling for %s, got %v", i, d.fen, d.in, m)
+		}
+		if out := m.UCI(); out != d.out {
+			t.Errorf("#%d %s: expected %s, got %s", i, d.fen, d.out, out)
+		}
+		if san := pos.MoveToSAN(m); san != d.san {
+			t.Errorf("#%d %s: expected %s, got %s", i, d.fen, d.san, san)
+		}
+		if actual, err := pos.SANToMove(d.san); err != nil || actual != m {
+			t.Errorf("#%d %s: %s parsed as %v (%v), expected %v", i, d.fen, d.san, actual, err, m)
+		}
+
+		before := pos.String()
+		pos.DoMove(m)
+		if fen := pos.String(); fen != d.after {
+			t.Errorf("#%d %s: expected %s after %s, got %s", i, d.fen, d.after, d.in, fen)
+		}
+		pos.UndoMove()
+		if fen := pos.String(); fen != before {
+			t.Errorf("#%d %s: expected %s after undo, got %s", i, d.fen, before, fen)
+		}
+	}
+}
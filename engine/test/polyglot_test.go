@@ -5,9 +5,12 @@ import (
 	"testing"
 )
 
-// Tests that the zobrist key is a correct polyglot key.
+// Tests that the polyglot key is correct in every variant and
+// that for standard chess the zobrist key is the polyglot key.
 // Testdata from http://hgm.nubati.net/book_format.html
 func TestPolyglotKey(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
 	data := []struct {
 		key uint64
 		fen string
@@ -38,14 +41,50 @@ func TestPolyglotKey(t *testing.T) {
 		{0x82cb1da07293cfb3, "r3k2r/8/8/8/4P3/8/8/R3K2R b KQkq e3 0 1"},
 	}
 
-	for i, d := range data {
-		pos, _ := PositionFromFEN(d.fen)
-		if d.key != pos.Zobrist() {
-			t.Errorf("#%d expected %08x got %08x for %s", i, d.key, pos.Zobrist(), d.fen)
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		for i, d := range data {
+			pos, _ := PositionFromFEN(d.fen)
+			if d.key != pos.PolyglotKey() {
+				t.Errorf("#%d expected %08x got %08x for %s", i, d.key, pos.PolyglotKey(), d.fen)
+			}
+			if variant == VARIANT_Standard && d.key != pos.Zobrist() {
+				t.Errorf("#%d expected zobrist key %08x got %08x for %s", i, d.key, pos.Zobrist(), d.fen)
+			}
 		}
 	}
 }
 
+// Tests that the same position has different keys in different variants.
+func TestZobristVariant(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	defer func(ht *HashTable) { GlobalHashTable = ht }(GlobalHashTable)
+	GlobalHashTable = NewHashTable(1)
+
+	for _, fen := range append(testFENs, testRacingKingsFENs...) {
+		pos, _ := PositionFromFEN(fen)
+		Variant = VARIANT_Standard
+		standard := pos.Zobrist()
+		Variant = VARIANT_Racing_Kings
+		racingKings := pos.Zobrist()
+		if standard == racingKings {
+			t.Errorf("%s: same zobrist key %08x in both variants", fen, standard)
+		}
+	}
+
+	// Entries stored for one variant are not found in the other.
+	pos, _ := PositionFromFEN(START_FENS[VARIANT_Racing_Kings])
+	Variant = VARIANT_Standard
+	GlobalHashTable.put(pos, hashEntry{kind: exact, depth: 1})
+	if entry := GlobalHashTable.get(pos); entry.kind != exact {
+		t.Errorf("expected the entry to be found")
+	}
+	Variant = VARIANT_Racing_Kings
+	if entry := GlobalHashTable.get(pos); entry.kind != noEntry {
+		t.Errorf("expected no entry for Racing Kings, got %v", entry.kind)
+	}
+}
+
 func TestZobristUndo(t *testing.T) {
 	for g, game := range testGames {
 		moves := strings.Fields(game)
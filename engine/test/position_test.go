@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"fmt"
 	"log"
 	"strings"
 	"testing"
@@ -22,10 +23,12 @@ type testEngine struct {
 
 // Move does uci move (e.g. a1h8).
 // If m == "", then it does the null move.
+// The move needs to be pseudo-legal only, so the
+// tests can play moves which leave the king in check.
 func (te *testEngine) Move(m string) {
 	move := Move(0)
 	if m != "" {
-		move, _ = te.Pos.UCIToMove(m)
+		move = pseudoLegalMove(te.Pos, m)
 	}
 	if te.Pos.SideToMove == move.Capture().Color() {
 		te.T.Fatalf("%v cannot capture its own color (move %v)",
@@ -35,6 +38,19 @@ func (te *testEngine) Move(m string) {
 	te.Pos.DoMove(move)
 }
 
+// pseudoLegalMove returns the pseudo-legal move m given in UCI format.
+// Returns NullMove if there is no such move.
+func pseudoLegalMove(pos *Position, m string) Move {
+	var moves []Move
+	pos.GenerateMoves(All, &moves)
+	for _, move := range moves {
+		if move.UCI() == m {
+			return move
+		}
+	}
+	return NullMove
+}
+
 func (te *testEngine) Undo() {
 	l := len(te.moves) - 1
 	te.Pos.UndoMove()
@@ -302,6 +318,27 @@ func TestCastleMovesPieces(t *testing.T) {
 	te.Piece(SquareE8, BlackKing)
 }
 
+func TestCastleRookShieldsKingChess960(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	// After O-O-O the rook on a1 would attack the king on c1.
+	m := MakeMove(Castling, SquareE1, SquareB1, NoPiece, WhiteKing)
+	pos, _ := PositionFromFEN("4k3/8/8/8/8/8/8/rR2K3 w B - 0 1")
+	if pos.IsPseudoLegal(m) {
+		t.Errorf("%v: expected %v to be invalid", pos, m)
+	}
+	te := &testEngine{T: t, Pos: pos}
+	te.King([]string{"e1d1", "e1d2", "e1e2", "e1f1", "e1f2"})
+
+	pos, _ = PositionFromFEN("4k3/8/8/8/8/8/r7/1R2K3 w B - 0 1")
+	if !pos.IsPseudoLegal(m) {
+		t.Errorf("%v: expected %v to be valid", pos, m)
+	}
+	te = &testEngine{T: t, Pos: pos}
+	te.King([]string{"e1d1", "e1d2", "e1e2", "e1f1", "e1f2", "e1c1"})
+}
+
 func TestCastleRightsAreUpdated(t *testing.T) {
 	pos, _ := PositionFromFEN(testBoard1)
 	pos.SetCastlingAbility(WhiteOOO)
@@ -448,6 +485,9 @@ func TestGenPawnAttackMoves2(t *testing.T) {
 }
 
 func TestGenPawnEnpassant(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	pos := NewPosition()
 	pos.SetSideToMove(White)
 	pos.Put(SquareH1, WhiteKing)
@@ -673,6 +713,9 @@ func TestPanicPosition(t *testing.T) {
 }
 
 func TestHasLegalMoves(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	tests := []struct {
 		fen string
 		has bool
@@ -937,6 +980,9 @@ func TestLastMove(t *testing.T) {
 }
 
 func TestHalfMoveClock(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	data := []struct {
 		fen  string
 		move string
@@ -957,3 +1003,153 @@ func TestHalfMoveClock(t *testing.T) {
 		}
 	}
 }
+
+func TestClone(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN(FENStartPos)
+	te := &testEngine{T: t, Pos: pos}
+	te.Move("b1c3")
+	te.Move("b8c6")
+	te.Move("c3b1")
+	te.Move("c6b8")
+
+	clone := pos.Clone()
+	if clone.String() != pos.String() || clone.Zobrist() != pos.Zobrist() {
+		t.Fatalf("expected %s, got %s", pos, clone)
+	}
+
+	// The moves played on the clone don't change the original.
+	fen := pos.String()
+	tc := &testEngine{T: t, Pos: clone}
+	tc.Move("b1c3")
+	tc.Move("b8c6")
+	tc.Move("c3b1")
+	tc.Move("c6b8")
+	if actual := pos.String(); actual != fen {
+		t.Errorf("expected original %s, got %s", fen, actual)
+	}
+	if pos.GetNoStates() != 5 {
+		t.Errorf("expected 5 states in original, got %d", pos.GetNoStates())
+	}
+
+	// The clone remembers the moves played before it was made.
+	if clone.ThreeFoldRepetition() != 3 {
+		t.Errorf("three fold repetition expected")
+	}
+	if pos.ThreeFoldRepetition() == 3 {
+		t.Errorf("three fold repetition not expected")
+	}
+	for i := 0; i < 8; i++ {
+		clone.UndoMove()
+	}
+	if expected := FENStartPos; clone.String() != expected {
+		t.Errorf("expected %s after undo, got %s", expected, clone)
+	}
+}
+
+func TestCloneConcurrent(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		fen := START_FENS[variant]
+		if variant == VARIANT_Standard {
+			fen = "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"
+		}
+		pos, _ := PositionFromFEN(fen)
+		nodes := perftByDoMove(pos, 3)
+		var sans []string
+		for _, m := range pos.GetLegalMoves(GET_ALL) {
+			sans = append(sans, pos.MoveToSAN(m))
+		}
+
+		// Each goroutine generates moves, converts them and searches its clone.
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			go func(clone *Position) {
+				if n := perftByDoMove(clone, 3); n != nodes {
+					errs <- fmt.Errorf("%s: expected %d nodes, got %d", fen, nodes, n)
+					return
+				}
+				for i, m := range clone.GetLegalMoves(GET_ALL) {
+					if san := clone.MoveToSAN(m); san != sans[i] {
+						errs <- fmt.Errorf("%s: expected %s, got %s", fen, sans[i], san)
+						return
+					}
+				}
+				eng := NewEngine(clone, nil, Options{HashTable: NewHashTable(1)})
+				if pv := eng.Play(NewFixedDepthTimeControl(clone, 3)); len(pv) == 0 {
+					errs <- fmt.Errorf("%s: expected a move", fen)
+					return
+				}
+				if clone.String() != fen {
+					errs <- fmt.Errorf("%s: clone changed to %s", fen, clone)
+					return
+				}
+				errs <- nil
+			}(pos.Clone())
+		}
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	root := "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 3 10"
+	pos, _ := PositionFromFEN(root)
+	if len(pos.History()) != 0 || pos.RootFEN() != root {
+		t.Fatalf("expected no history, got %v from %s", pos.History(), pos.RootFEN())
+	}
+
+	te := &testEngine{T: t, Pos: pos}
+	te.Move("e1g1")
+	te.Move("a8a1")
+	te.Move("f1a1")
+	after := pos.String()
+
+	if actual := pos.History(); len(actual) != 3 || actual[0] != te.moves[0] || actual[2] != te.moves[2] {
+		t.Errorf("expected %v, got %v", te.moves, actual)
+	}
+	if actual := strings.Join(pos.HistoryUCI(), " "); actual != "e1g1 a8a1 f1a1" {
+		t.Errorf("expected UCI e1g1 a8a1 f1a1, got %s", actual)
+	}
+	if actual := strings.Join(pos.HistorySAN(), " "); actual != "O-O Rxa1 Rxa1" {
+		t.Errorf("expected SAN O-O Rxa1 Rxa1, got %s", actual)
+	}
+	if actual := pos.RootFEN(); actual != root {
+		t.Errorf("expected root %s, got %s", root, actual)
+	}
+
+	replayed := []string{
+		root,
+		"r3k2r/8/8/8/8/8/8/R4RK1 b kq - 4 10",
+		"4k2r/8/8/8/8/8/8/r4RK1 w k - 0 11",
+		after,
+	}
+	for n, expected := range replayed {
+		actual, err := pos.Replay(n)
+		if err != nil {
+			t.Errorf("replay %d: unexpected error %v", n, err)
+		} else if actual.String() != expected {
+			t.Errorf("replay %d: expected %s, got %s", n, expected, actual)
+		} else if len(actual.History()) != n {
+			t.Errorf("replay %d: expected %d moves, got %d", n, n, len(actual.History()))
+		}
+	}
+	for _, n := range []int{-1, 4} {
+		if _, err := pos.Replay(n); err == nil {
+			t.Errorf("replay %d: expected error", n)
+		}
+	}
+	if actual := pos.String(); actual != after {
+		t.Errorf("expected %s unchanged, got %s", after, actual)
+	}
+}
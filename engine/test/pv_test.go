@@ -6,6 +6,9 @@ import (
 )
 
 func TestPV(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	pos, _ := PositionFromFEN(FENStartPos)
 	pvTable := newPvTable()
 	for _, game := range testGames {
@@ -37,3 +40,118 @@ func TestPV(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the principal variation continues with
+// the moves from the transposition table.
+func TestPVFallsBackToHashTable(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+	defer func(ht *HashTable) { GlobalHashTable = ht }(GlobalHashTable)
+	GlobalHashTable = NewHashTable(1)
+
+	pos, _ := PositionFromFEN(FENStartPos)
+
+	// The first two moves are in the pv table, the next two
+	// in the hash table and the last one is an upper bound.
+	pvTable := newPvTable()
+	kinds := []hashKind{exact, exact, exact, failedHigh, failedLow}
+	var moves []Move
+	for i, str := range []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5"} {
+		m, _ := pos.UCIToMove(str)
+		moves = append(moves, m)
+		if i < 2 {
+			pvTable.Put(pos, m)
+		} else {
+			GlobalHashTable.put(pos, hashEntry{kind: kinds[i], move: m, depth: 1})
+		}
+		pos.DoMove(m)
+	}
+	for range moves {
+		pos.UndoMove()
+	}
+
+	pv := pvTable.Get(pos)
+	if len(pv) != 4 {
+		t.Fatalf("expected 4 moves, got %v", pv)
+	}
+	for i := range pv {
+		if moves[i] != pv[i] {
+			t.Errorf("#%d expected move %v, got %v", i, moves[i], pv[i])
+		}
+	}
+
+	// An illegal move in the hash table is ignored.
+	pos.DoMove(moves[0])
+	pos.DoMove(moves[1])
+	illegal, _ := pos.UCIToMove("e1e3") // the king moves one square
+	GlobalHashTable.put(pos, hashEntry{kind: exact, move: illegal, depth: 1})
+	pos.UndoMove()
+	pos.UndoMove()
+	if pv := pvTable.Get(pos); len(pv) != 2 {
+		t.Errorf("expected 2 moves, got %v", pv)
+	}
+}
+
+// Tests that Play returns a ponder move and that the
+// triangular array finds the same best move.
+func TestPlayReturnsPonderMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		fens := testFENs[:6]
+		if variant == VARIANT_Racing_Kings {
+			fens = testRacingKingsFENs
+		}
+
+		for _, fen := range fens {
+			var pvs [2][]Move
+			for i, triangular := range []bool{false, true} {
+				GlobalHashTable.Clear()
+				pos, _ := PositionFromFEN(fen)
+				eng := NewEngine(pos, nil, Options{TriangularPV: triangular})
+				tc := NewFixedDepthTimeControl(pos, 4)
+				tc.Start(false)
+				pvs[i] = eng.Play(tc)
+
+				if len(pvs[i]) == 1 {
+					pos.DoMove(pvs[i][0])
+					if moves := pos.GetLegalMoves(GET_ALL); len(moves) != 0 {
+						t.Errorf("%s: expected a ponder move, triangular %v", fen, triangular)
+					}
+					pos.UndoMove()
+				}
+
+				// All moves on the principal variation must be legal.
+				for _, m := range pvs[i] {
+					if !isLegalMove(pos, m) {
+						t.Errorf("%s: illegal move %v on pv %v, triangular %v", fen, m, pvs[i], triangular)
+						break
+					}
+					pos.DoMove(m)
+				}
+			}
+
+			pos, _ := PositionFromFEN(fen)
+			if len(pos.GetLegalMoves(GET_ALL)) == 0 {
+				if len(pvs[0]) != 0 || len(pvs[1]) != 0 {
+					t.Errorf("%s: expected no moves, got %v and %v", fen, pvs[0], pvs[1])
+				}
+				continue
+			}
+			if len(pvs[0]) == 0 || len(pvs[1]) == 0 || pvs[0][0] != pvs[1][0] {
+				t.Errorf("%s: pv table %v and triangular pv %v have different best moves", fen, pvs[0], pvs[1])
+			}
+		}
+	}
+}
+
+// isLegalMove returns true if m is a legal move in pos.
+func isLegalMove(pos *Position, m Move) bool {
+	for _, l := range pos.GetLegalMoves(GET_ALL) {
+		if l == m {
+			return true
+		}
+	}
+	return false
+}
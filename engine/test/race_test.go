@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"testing"
+)
+
+// raceQuiescence returns the score of the quiescence search in pos.
+func raceQuiescence(pos *Position) int32 {
+	eng := NewEngine(pos, nil, Options{})
+	tc := NewFixedDepthTimeControl(pos, 1)
+	tc.Start(false)
+	eng.rootPly = pos.Ply
+	eng.timeControl = tc
+	eng.stack.Reset(pos)
+	return eng.searchQuiescence(-InfinityScore, InfinityScore, raceQuiescenceDepth)
+}
+
+func TestRacingKingsQuiescence(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	const (
+		win  = 1
+		draw = 0
+		loss = -1
+		none = 2 // neither a known win nor a known loss
+	)
+
+	data := []struct {
+		fen      string
+		expected int
+	}{
+		// Black walks to the 8th rank next move.
+		{"8/6k1/8/8/8/8/8/K7 w - - 0 1", loss},
+		// The rook stops Black by covering the 8th rank.
+		{"8/6k1/8/8/8/8/8/R6K w - - 0 1", none},
+		// Black cannot stop White, nor reach the 8th rank.
+		{"8/1K6/8/8/8/8/8/6k1 b - - 0 1", loss},
+		// Black gets there first.
+		{"8/1K4k1/8/8/8/8/8/8 b - - 0 1", win},
+		// White gets there first, but Black follows.
+		{"8/1K4k1/8/8/8/8/8/8 w - - 0 1", draw},
+		// White reached the 8th rank and Black cannot follow.
+		{"1K6/8/8/8/6k1/8/8/8 b - - 0 1", loss},
+		// White reached the 8th rank and Black follows.
+		{"1K6/6k1/8/8/8/8/8/8 b - - 0 1", draw},
+		// The king advances to the 7th rank and cannot be stopped.
+		{"8/8/1K6/8/8/8/8/6k1 w - - 0 1", win},
+		// The queen guards the 8th rank.
+		{"8/8/1K6/8/8/8/8/q5k1 w - - 0 1", none},
+		// Black follows White to the 7th and the 8th rank.
+		{"8/8/1k3K2/8/8/8/8/8 w - - 0 1", none},
+		// Black steps to the 7th rank and follows White to the 8th rank.
+		{"8/6K1/1k6/8/8/8/8/8 b - - 0 1", draw},
+	}
+
+	for i, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		score := raceQuiescence(pos)
+
+		actual := none
+		switch {
+		case score > KnownWinScore:
+			actual = win
+		case score < KnownLossScore:
+			actual = loss
+		case d.expected == draw && score == 0:
+			actual = draw
+		}
+		if actual != d.expected {
+			t.Errorf("#%d %s: expected %d, got %d (score %d)", i, d.fen, d.expected, actual, score)
+		}
+	}
+}
+
+func TestRacingKingsRaceMoves(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	for i, fen := range []string{
+		// The rook must cover the 8th rank.
+		"8/6k1/8/8/8/8/8/R6K w - - 0 1",
+		// The king must advance.
+		"8/8/1K6/8/8/8/8/6k1 w - - 0 1",
+		// The king must go to the 8th rank.
+		"8/1K4k1/8/8/8/8/8/8 b - - 0 1",
+	} {
+		pos, _ := PositionFromFEN(fen)
+		tc := NewFixedDepthTimeControl(pos, 1)
+		tc.Start(false)
+		eng := NewEngine(pos, nil, Options{})
+		pv := eng.Play(tc)
+		if len(pv) == 0 {
+			t.Errorf("#%d %s: no move", i, fen)
+			continue
+		}
+
+		them := pos.SideToMove.Opposite()
+		pos.DoMove(pv[0])
+		if pos.Result().Outcome == Ongoing && pos.canReachBaseRank(them) {
+			t.Errorf("#%d %s: %v lets the opponent reach the 8th rank", i, fen, pv[0])
+		}
+		if i == 1 && pos.ByPiece(White, King).AsSquare().Rank() != 6 {
+			t.Errorf("#%d %s: expected the king to advance, got %v", i, fen, pv[0])
+		}
+		if i == 2 && pos.Result().Outcome != BlackWins {
+			t.Errorf("#%d %s: expected Black to win, got %v", i, fen, pv[0])
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestRender(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+
+	data := []struct {
+		golden  string
+		variant int
+		fen     string
+		moves   []string
+		opts    RenderOptions
+	}{
+		{"start_ascii", VARIANT_Standard, FENStartPos, nil,
+			RenderOptions{}},
+		{"start_coordinates", VARIANT_Standard, FENStartPos, nil,
+			RenderOptions{Coordinates: true}},
+		{"start_flipped", VARIANT_Standard, FENStartPos, nil,
+			RenderOptions{Coordinates: true, Flip: true}},
+		{"start_unicode", VARIANT_Standard, FENStartPos, nil,
+			RenderOptions{Unicode: true, Coordinates: true}},
+		{"check_color", VARIANT_Standard, FENStartPos, []string{"e2e4", "f7f6", "d1h5"},
+			RenderOptions{Coordinates: true, Color: true}},
+		{"enpassant_markers", VARIANT_Standard, FENStartPos, []string{"e2e4", "a7a6", "e4e5", "d7d5"},
+			RenderOptions{Coordinates: true, Markers: true}},
+		{"racingkings_goal", VARIANT_Racing_Kings, "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1", []string{"h2h3"},
+			RenderOptions{Coordinates: true, Color: true, GoalRank: true}},
+	}
+
+	for _, d := range data {
+		Variant = d.variant
+		pos, _ := PositionFromFEN(d.fen)
+		for _, str := range d.moves {
+			m, err := pos.UCIToMove(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos.DoMove(m)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := pos.Render(buf, d.opts); err != nil {
+			t.Fatalf("%s: %v", d.golden, err)
+		}
+
+		path := filepath.Join("testdata", "render", d.golden+".golden")
+		if *update {
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", d.golden, expected, buf.Bytes())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package engine
+
+import "testing"
+
+func TestResultStandard(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	data := []struct {
+		fen    string
+		result Result
+	}{
+		{FENStartPos, Result{Ongoing, NoReason}},
+		{"4b2k/5pQ1/5P2/7p/4P3/2P1K1P1/1r6/8 b - - 10 57", Result{WhiteWins, Checkmate}},
+		{"5k2/5P2/5K2/8/8/8/8/8 b - - 0 1", Result{Draw, Stalemate}},
+		{"4k3/8/8/8/8/8/8/4KB2 w - - 0 1", Result{Draw, NoMatingMaterial}},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 100 80", Result{Draw, FiftyMoves}},
+		{"4k3/8/8/8/8/8/8/R7 w - - 0 1", Result{BlackWins, KingCaptured}},
+	}
+
+	for _, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		if got := pos.Result(); got != d.result {
+			t.Errorf("%s: expected %v, got %v", d.fen, d.result, got)
+		}
+	}
+}
+
+func TestResultRepetition(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN(FENStartPos)
+	for i, str := range []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"} {
+		if got := pos.Result(); got.Outcome != Ongoing {
+			t.Fatalf("#%d expected game to continue, got %v", i, got)
+		}
+		m, _ := pos.UCIToMove(str)
+		pos.DoMove(m)
+	}
+	if got, expected := pos.Result(), (Result{Draw, Repetition}); got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestResultRacingKings(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	data := []struct {
+		fen    string
+		result Result
+	}{
+		{"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1", Result{Ongoing, NoReason}},
+		// White reached the 8th rank and Black can follow.
+		{"6K1/1k6/8/8/8/8/8/8 b - - 0 1", Result{Ongoing, NoReason}},
+		// White reached the 8th rank and Black cannot follow.
+		{"6K1/8/1k6/8/8/8/8/8 b - - 0 1", Result{WhiteWins, KingReachedRank8}},
+		// Black's reply reached the 8th rank, too.
+		{"1k4K1/8/8/8/8/8/8/8 w - - 0 1", Result{Draw, BothKingsOnRank8}},
+		// Black's reply didn't reach the 8th rank.
+		{"6K1/8/1k6/8/8/8/8/8 w - - 0 1", Result{WhiteWins, KingReachedRank8}},
+		// Black reached the 8th rank first.
+		{"1k6/8/8/8/8/8/6K1/8 w - - 0 1", Result{BlackWins, KingReachedRank8}},
+		// Pieces on the board don't make a draw.
+		{"8/8/8/8/8/8/kr6/6K1 w - - 0 1", Result{Ongoing, NoReason}},
+	}
+
+	for _, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		if got := pos.Result(); got != d.result {
+			t.Errorf("%s: expected %v, got %v", d.fen, d.result, got)
+		}
+	}
+}
+
+// Tests that after White reaches the 8th rank Black
+// has exactly one more move to reach it, too.
+func TestRacingKingsLastMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	pos, _ := PositionFromFEN("8/1k4K1/8/8/8/8/8/8 w - - 0 1")
+	m, _ := pos.UCIToMove("g7g8")
+	pos.DoMove(m)
+	if got := pos.Result(); got.Outcome != Ongoing {
+		t.Fatalf("expected Black to have one more move, got %v", got)
+	}
+	moves := pos.GetLegalMoves(GET_ALL)
+	if len(moves) != 3 {
+		t.Errorf("expected 3 king moves to the 8th rank, got %v", moves)
+	}
+	for _, m := range moves {
+		pos.DoMove(m)
+		if got, expected := pos.Result(), (Result{Draw, BothKingsOnRank8}); got != expected {
+			t.Errorf("after %v expected %v, got %v", m, expected, got)
+		}
+		if n := len(pos.GetLegalMoves(GET_ALL)); n != 0 {
+			t.Errorf("after %v expected no legal moves, got %d", m, n)
+		}
+		pos.UndoMove()
+	}
+}
+
+func TestResultString(t *testing.T) {
+	if s := (Result{WhiteWins, KingReachedRank8}).String(); s != "1-0 {king reached the 8th rank}" {
+		t.Errorf("got %q", s)
+	}
+	if s := (Result{Ongoing, NoReason}).String(); s != "*" {
+		t.Errorf("got %q", s)
+	}
+}
@@ -40,6 +40,9 @@ func seeSlow(pos *Position, m Move, score int32) int32 {
 }
 
 func TestSEE(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
 	good, bad := 0, 0
 	for i, fen := range testFENs {
 		var moves []Move
@@ -65,6 +68,61 @@ func TestSEE(t *testing.T) {
 	}
 }
 
+func TestSEEBefore(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		fens := testFENs
+		if variant == VARIANT_Racing_Kings {
+			fens = testRacingKingsFENs
+		}
+		for i, fen := range fens {
+			var moves []Move
+			pos, _ := PositionFromFEN(fen)
+			pos.GenerateMoves(All, &moves)
+			for _, m := range moves {
+				actual := seeBefore(pos, m)
+				pos.DoMove(m)
+				expected := see(pos, m)
+				pos.UndoMove()
+
+				if expected != actual {
+					t.Errorf("#%d expected %d, got %d\nfor %v on %v", i, expected, actual, m, fen)
+				}
+			}
+		}
+	}
+}
+
+func TestSEERacingKings(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	data := []struct {
+		fen string
+		m   string
+		see int32
+	}{
+		// The bishop recaptures the knight.
+		{"8/8/4b2k/3r4/8/2N5/8/K7 w - - 0 1", "c3d5", 500 - 300},
+		// Nothing defends the rook.
+		{"8/8/7k/3r4/8/2N5/8/K7 w - - 0 1", "c3d5", 500},
+		// The bishop cannot recapture because it would give check to the king on b3.
+		{"8/8/4b2k/3r4/8/1KN5/8/8 w - - 0 1", "c3d5", 500},
+	}
+
+	for i, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		m, err := pos.UCIToMove(d.m)
+		if err != nil {
+			t.Fatalf("#%d cannot parse %s: %v", i, d.m, err)
+		}
+		if actual := seeBefore(pos, m); actual != d.see {
+			t.Errorf("#%d expected see %d, got %d for %v on %v", i, d.see, actual, m, d.fen)
+		}
+	}
+}
+
 // A benchmark position from http://www.stmintz.com/ccc/index.php?id=60880
 var seeBench = "1rr3k1/4ppb1/2q1bnp1/1p2B1Q1/6P1/2p2P2/2P1B2R/2K4R w - - 0 1"
 
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRacingKingsTimeAllocator(t *testing.T) {
+	data := []struct {
+		fen         string
+		movesToGo   int
+		criticality int
+	}{
+		// Both kings have to advance 6 ranks.
+		{"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1", 14, 100},
+		// White leads by 3 ranks.
+		{"8/8/8/7K/8/8/k7/8 w - - 0 1", 8, 110},
+		// White is on the 6th rank, far ahead of Black.
+		{"8/8/7K/8/8/8/k7/8 w - - 0 1", 8, 125},
+		// Both kings are on the 6th rank, a close race.
+		{"8/8/k6K/8/8/8/8/8 w - - 0 1", 8, 150},
+		// White reached the 8th rank, Black has one more move.
+		{"7K/8/8/8/8/8/k7/8 b - - 0 1", 8, 125},
+	}
+
+	ta := racingKingsTimeAllocator{}
+	for _, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		if got := ta.MovesToGo(pos); got != d.movesToGo {
+			t.Errorf("%s: expected %d moves to go, got %d", d.fen, d.movesToGo, got)
+		}
+		if got := ta.Criticality(pos); got != d.criticality {
+			t.Errorf("%s: expected criticality %d, got %d", d.fen, d.criticality, got)
+		}
+	}
+}
+
+// Tests that more time is spent when the kings approach the 8th rank.
+func TestRacingKingsCriticalPositionTime(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	searchTime := func(fen string) time.Duration {
+		pos, _ := PositionFromFEN(fen)
+		tc := NewTimeControl(pos, false)
+		tc.WTime, tc.MovesToGo = 60*time.Second, 10
+		tc.Start(false)
+		return tc.searchTime
+	}
+
+	normal := searchTime("8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1")
+	critical := searchTime("8/8/k6K/8/8/8/8/8 w - - 0 1")
+	if normal != 6*time.Second {
+		t.Errorf("expected 6s in the start position, got %v", normal)
+	}
+	if critical != normal*150/100 {
+		t.Errorf("expected %v in the critical position, got %v", normal*150/100, critical)
+	}
+}
+
+// Tests that a critical position doesn't use up the clock.
+func TestRacingKingsTimeLimits(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Racing_Kings
+
+	// 60s/8 moves to go = 7.5s scaled by the criticality,
+	// the search is aborted after a quarter of the remaining time.
+	data := []struct {
+		fen    string
+		search time.Duration
+	}{
+		{"8/7K/8/8/8/8/k7/8 w - - 0 1", 9375 * time.Millisecond},
+		{"8/k6K/8/8/8/8/8/8 w - - 0 1", 11250 * time.Millisecond},
+		{"7K/8/8/8/8/8/k7/8 b - - 0 1", 9375 * time.Millisecond},
+	}
+
+	for _, d := range data {
+		pos, _ := PositionFromFEN(d.fen)
+		start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		tc := NewTimeControl(pos, false)
+		tc.Clock = NewFakeClock(start)
+		tc.WTime, tc.BTime = 60*time.Second, 60*time.Second
+		tc.Start(false)
+
+		if tc.searchTime != d.search {
+			t.Errorf("%s: expected thinking time %v, got %v", d.fen, d.search, tc.searchTime)
+		}
+		if got := tc.stopDeadline.Sub(start); got != 15*time.Second {
+			t.Errorf("%s: expected stop deadline 15s, got %v", d.fen, got)
+		}
+	}
+}
+
+// fixedTimeAllocator returns the same estimates for every position.
+type fixedTimeAllocator struct{}
+
+func (fixedTimeAllocator) Branch(pos *Position) int      { return 3 }
+func (fixedTimeAllocator) MovesToGo(pos *Position) int   { return 7 }
+func (fixedTimeAllocator) Criticality(pos *Position) int { return 50 }
+func (fixedTimeAllocator) StopShare(pos *Position) int   { return 2 }
+
+func TestTimeAllocatorPerVariant(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	defer func(ta TimeAllocator) { TimeAllocators[VARIANT_Racing_Kings] = ta }(TimeAllocators[VARIANT_Racing_Kings])
+
+	pos, _ := PositionFromFEN(FENStartPos)
+	Variant = VARIANT_Standard
+	if tc := NewTimeControl(pos, false); tc.MovesToGo != defaultMovesToGo || tc.criticality != 100 || tc.stopShare != 0 {
+		t.Errorf("expected standard estimates, got %d moves to go, criticality %d and stop share %d",
+			tc.MovesToGo, tc.criticality, tc.stopShare)
+	}
+
+	Variant = VARIANT_Racing_Kings
+	TimeAllocators[VARIANT_Racing_Kings] = fixedTimeAllocator{}
+	tc := NewTimeControl(pos, false)
+	if tc.MovesToGo != 7 || tc.branch != 3 || tc.criticality != 50 || tc.stopShare != 2 {
+		t.Errorf("expected the variant's allocator to be used, got %d moves to go, branch %d, criticality %d and stop share %d",
+			tc.MovesToGo, tc.branch, tc.criticality, tc.stopShare)
+	}
+}
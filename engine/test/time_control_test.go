@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// newFakeTimeControl returns a started TimeControl for the start position
+// with 30s for 30 moves, i.e. 1s per move and 250ms for the next depth.
+func newFakeTimeControl(clock *FakeClock) *TimeControl {
+	pos, _ := PositionFromFEN(FENStartPos)
+	tc := NewTimeControl(pos, false)
+	tc.WTime, tc.BTime = 30*time.Second, 30*time.Second
+	tc.Clock = clock
+	tc.Start(false)
+	return tc
+}
+
+// nextDepthUntil returns how long after start depth 3 can still be started.
+func nextDepthUntil(tc *TimeControl, clock *FakeClock) time.Duration {
+	start := clock.Now()
+	for tc.NextDepth(3) {
+		clock.Advance(time.Millisecond)
+	}
+	return clock.Now().Sub(start)
+}
+
+func TestTimeControlIterationDone(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN(FENStartPos)
+	var moves []Move
+	pos.GenerateMoves(All, &moves)
+	m1, m2 := moves[0], moves[1]
+
+	data := []struct {
+		name  string
+		moves []Move
+		score []int32
+		until time.Duration
+	}{
+		{"no feedback", nil, nil, 251 * time.Millisecond},
+		{"first depth", []Move{m1}, []int32{0}, 251 * time.Millisecond},
+		{"best move changed", []Move{m1, m2}, []int32{0, 0}, 376 * time.Millisecond},
+		{"stable best move", []Move{m1, m1, m1, m1}, []int32{0, 0, 0, 0}, 176 * time.Millisecond},
+		{"small score drop", []Move{m1, m1}, []int32{0, -30}, 376 * time.Millisecond},
+		{"large score drop", []Move{m1, m1}, []int32{0, -60}, 501 * time.Millisecond},
+		{"changed and dropped", []Move{m1, m2}, []int32{0, -60}, 626 * time.Millisecond},
+	}
+
+	for _, d := range data {
+		clock := NewFakeClock(time.Time{})
+		tc := newFakeTimeControl(clock)
+		for i := range d.moves {
+			tc.IterationDone(d.moves[i], d.score[i], 20)
+		}
+		if until := nextDepthUntil(tc, clock); until != d.until {
+			t.Errorf("%s: expected next depth until %v, got %v", d.name, d.until, until)
+		}
+	}
+}
+
+func TestTimeControlSingleMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	clock := NewFakeClock(time.Time{})
+	tc := newFakeTimeControl(clock)
+	tc.IterationDone(NullMove, 0, 1)
+
+	// The first depths are always searched.
+	if !tc.NextDepth(2) {
+		t.Errorf("expected depth 2 to be searched")
+	}
+	clock.Advance(time.Millisecond)
+	if tc.NextDepth(3) {
+		t.Errorf("expected no more depths with a single legal move")
+	}
+}
+
+func TestPlaySingleMove(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	pos, _ := PositionFromFEN("7k/5Q2/8/1p6/PP6/8/8/6K1 b - - 0 1")
+	if n := len(pos.GetLegalMoves(GET_ALL)); n != 1 {
+		t.Fatalf("expected a single legal move, got %d", n)
+	}
+
+	tc := NewTimeControl(pos, false)
+	tc.WTime, tc.BTime = time.Hour, time.Hour
+	tc.Start(false)
+
+	start := time.Now()
+	eng := NewEngine(pos, nil, Options{})
+	eng.Play(tc)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a quick move, took %v", elapsed)
+	}
+}
+
+func TestTimeControlDeadlines(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	Variant = VARIANT_Standard
+
+	const ms = time.Millisecond
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// In the start position the branching factor is 4 and
+	// increases by one for each of movestogo <= 4, 2 and 1.
+	data := []struct {
+		name      string
+		fen       string
+		predicted bool
+		setup     func(tc *TimeControl)
+		ponder    time.Duration // time pondering before ponderhit, if any
+		search    time.Duration // deadline for the next depth after start
+		stop      time.Duration // deadline to abort the search after start
+	}{{
+		// 60s/30 = 2s thinking time.
+		name:   "sudden death",
+		setup:  func(tc *TimeControl) { tc.WTime = 60 * time.Second },
+		search: 500 * ms,
+		stop:   8000 * ms,
+	}, {
+		// (10s + 29*1s)/30 = 1.3s thinking time.
+		name: "increment",
+		setup: func(tc *TimeControl) {
+			tc.WTime, tc.WInc = 10*time.Second, time.Second
+		},
+		search: 325 * ms,
+		stop:   5200 * ms,
+	}, {
+		// 10s/2 = 5s thinking time, limited by 10s-20ms.
+		name: "movestogo",
+		setup: func(tc *TimeControl) {
+			tc.WTime, tc.MovesToGo = 10*time.Second, 2
+		},
+		search: 5 * time.Second / 6,
+		stop:   9980 * ms,
+	}, {
+		// go movetime 1000, limited by 1s-20ms.
+		name: "movetime",
+		setup: func(tc *TimeControl) {
+			tc.WTime, tc.BTime, tc.MovesToGo = time.Second, time.Second, 1
+		},
+		search: 140 * ms,
+		stop:   980 * ms,
+	}, {
+		// Black uses its own time.
+		name: "black to move",
+		fen:  "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+		setup: func(tc *TimeControl) {
+			tc.WTime, tc.BTime = time.Second, 60*time.Second
+		},
+		search: 500 * ms,
+		stop:   8000 * ms,
+	}, {
+		// A predicted move gets 4/3 of the thinking time.
+		name:      "predicted",
+		predicted: true,
+		setup:     func(tc *TimeControl) { tc.WTime = 60 * time.Second },
+		search:    2 * time.Second * 4 / 3 / 4,
+		stop:      2 * time.Second * 4 / 3 * 4,
+	}, {
+		// The ponder time is credited from the thinking time.
+		name:   "ponderhit",
+		setup:  func(tc *TimeControl) { tc.WTime = 60 * time.Second },
+		ponder: 300 * ms,
+		search: 500 * ms,
+		stop:   300*ms + 7700*ms,
+	}, {
+		// Pondered longer than the thinking time.
+		name:   "long ponderhit",
+		setup:  func(tc *TimeControl) { tc.WTime = 60 * time.Second },
+		ponder: 10 * time.Second,
+		search: 10 * time.Second,
+		stop:   10*time.Second + 500*ms,
+	}}
+
+	for _, d := range data {
+		fen := d.fen
+		if fen == "" {
+			fen = FENStartPos
+		}
+		pos, _ := PositionFromFEN(fen)
+		clock := NewFakeClock(start)
+		tc := NewTimeControl(pos, d.predicted)
+		tc.Clock = clock
+		d.setup(tc)
+		tc.Start(d.ponder != 0)
+		tc.NextDepth(3)
+
+		if d.ponder != 0 {
+			// Deadlines are ignored while pondering.
+			clock.Advance(d.ponder)
+			if !tc.NextDepth(3) || tc.Stopped() {
+				t.Errorf("%s: expected search to continue while pondering", d.name)
+			}
+			tc.PonderHit()
+		}
+
+		if got := tc.searchDeadline.Sub(start); got != d.search {
+			t.Errorf("%s: expected search deadline %v, got %v", d.name, d.search, got)
+		}
+		if got := tc.stopDeadline.Sub(start); got != d.stop {
+			t.Errorf("%s: expected stop deadline %v, got %v", d.name, d.stop, got)
+		}
+
+		// Check the deadlines are used.
+		clock.Advance(start.Add(d.search).Sub(clock.Now()))
+		if !tc.NextDepth(3) {
+			t.Errorf("%s: expected next depth at the search deadline", d.name)
+		}
+		clock.Advance(time.Nanosecond)
+		if d.search < d.stop && tc.NextDepth(3) {
+			t.Errorf("%s: expected no next depth after the search deadline", d.name)
+		}
+		if tc.Stopped() {
+			t.Errorf("%s: expected search to continue before the stop deadline", d.name)
+		}
+		clock.Advance(start.Add(d.stop).Sub(clock.Now()) + time.Nanosecond)
+		if !tc.Stopped() {
+			t.Errorf("%s: expected search to stop after the stop deadline", d.name)
+		}
+	}
+}
+
+// Tests that the infinite time control doesn't overflow when
+// the time is extended by the criticality or by the search.
+func TestTimeControlInfiniteDoesNotStop(t *testing.T) {
+	defer func(v int) { Variant = v }(Variant)
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings} {
+		Variant = variant
+		// A close race is critical in Racing Kings.
+		pos, _ := PositionFromFEN("8/8/1k6/1K6/8/8/8/8 w - - 0 1")
+		clock := NewFakeClock(time.Time{})
+		tc := NewTimeControl(pos, true)
+		tc.Clock = clock
+		tc.Start(false)
+
+		m := MakeMove(Normal, SquareB5, SquareA5, NoPiece, WhiteKing)
+		tc.IterationDone(m, 0, 5)
+		tc.IterationDone(m+1, -100, 5) // the best move changed and the score dropped
+		clock.Advance(time.Hour)
+		if !tc.NextDepth(3) {
+			t.Errorf("variant %d: infinite time control stopped", variant)
+		}
+		if tc.Stopped() {
+			t.Errorf("variant %d: infinite time control stopped", variant)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// time_allocator.go implements the per variant estimates used to split the time.
+
+package engine
+
+// TimeAllocator estimates from the position how to spend the time on a move.
+type TimeAllocator interface {
+	// Branch returns the expected branching factor of the search.
+	Branch(pos *Position) int
+	// MovesToGo returns the expected number of moves until the end of the game.
+	MovesToGo(pos *Position) int
+	// Criticality returns the percent of the normal thinking time to spend on pos.
+	Criticality(pos *Position) int
+	// StopShare returns n if the search on pos must be aborted after
+	// 1/n of the remaining time, or 0 if it can use all the time.
+	StopShare(pos *Position) int
+}
+
+// TimeAllocators are the time allocators used by NewTimeControl for each variant.
+// Variants without an allocator use the one of standard chess.
+var TimeAllocators = map[int]TimeAllocator{
+	VARIANT_Standard:     standardTimeAllocator{},
+	VARIANT_Racing_Kings: racingKingsTimeAllocator{},
+}
+
+// timeAllocator returns the time allocator for the current variant.
+func timeAllocator() TimeAllocator {
+	if ta, ok := TimeAllocators[Variant]; ok {
+		return ta
+	}
+	return TimeAllocators[VARIANT_Standard]
+}
+
+// standardTimeAllocator splits the time evenly over a fixed number of moves.
+type standardTimeAllocator struct{}
+
+// Branch branches more when there are more pieces. With fewer pieces
+// there is less mobility and hash table kicks in more often.
+func (standardTimeAllocator) Branch(pos *Position) int {
+	branch := 2
+	for np := (pos.ByColor[White] | pos.ByColor[Black]).Count(); np > 0; np /= 6 {
+		branch++
+	}
+	return branch
+}
+
+func (standardTimeAllocator) MovesToGo(pos *Position) int {
+	return defaultMovesToGo
+}
+
+func (standardTimeAllocator) Criticality(pos *Position) int {
+	return 100
+}
+
+func (standardTimeAllocator) StopShare(pos *Position) int {
+	return 0
+}
+
+const (
+	minRacingKingsMovesToGo = 8
+	racingKingsStopShare    = 4
+)
+
+// racingKingsTimeAllocator estimates the remaining moves from the
+// kings' distance to the 8th rank and spends more time when
+// the kings approach it, because then every tempo decides the race.
+type racingKingsTimeAllocator struct {
+	standardTimeAllocator
+}
+
+// raceDistance returns the number of ranks col's king has to advance.
+func raceDistance(pos *Position, col Color) int {
+	king := pos.ByPiece(col, King)
+	if king == 0 {
+		return 0
+	}
+	return 7 - king.AsSquare().Rank()
+}
+
+// MovesToGo expects the game to end a few moves after
+// the leading king reaches the 8th rank. The estimate never
+// drops below minRacingKingsMovesToGo, because the race can
+// end in a draw or go on after a king is blocked.
+func (racingKingsTimeAllocator) MovesToGo(pos *Position) int {
+	lead := min(int32(raceDistance(pos, White)), int32(raceDistance(pos, Black)))
+	return int(max(2*lead+2, minRacingKingsMovesToGo))
+}
+
+// Criticality is at most 150% so a single move doesn't use up the clock.
+func (racingKingsTimeAllocator) Criticality(pos *Position) int {
+	us := raceDistance(pos, pos.SideToMove)
+	them := raceDistance(pos, pos.SideToMove.Opposite())
+
+	criticality := 100
+	if lead := min(int32(us), int32(them)); lead <= 2 {
+		// A king is on the 6th rank or above.
+		criticality += 25
+	} else if lead <= 3 {
+		criticality += 10
+	}
+	if us-them <= 1 && them-us <= 1 && us <= 3 {
+		// Close race, a single tempo decides the game.
+		criticality += 25
+	}
+	return criticality
+}
+
+// StopShare aborts the search after a quarter of the remaining time,
+// because the estimates are larger than in standard chess.
+func (racingKingsTimeAllocator) StopShare(pos *Position) int {
+	return racingKingsStopShare
+}
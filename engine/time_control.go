@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"math"
 	"sync"
 	"time"
 )
@@ -36,43 +37,59 @@ type TimeControl struct {
 	WTime, WInc time.Duration // time and increment for white.
 	BTime, BInc time.Duration // time and increment for black
 	Depth       int32         // maximum depth search (including)
+	Nodes       uint64        // maximum number of nodes to search, 0 for no limit
+	SearchMoves []Move        // moves to search at root, all moves if empty
 	MovesToGo   int           // number of remaining moves
+	Clock       Clock         // tells the time, must be set before Start
 
 	sideToMove Color
 	time, inc  time.Duration // time and increment for us
 	limit      time.Duration
 
-	predicted bool       // true if this move was predicted
-	branch    int        // branching factor
-	currDepth int32      // current depth searched
-	stopped   atomicFlag // true to stop the search
-	ponderhit atomicFlag // true if ponder was successful
+	predicted   bool       // true if this move was predicted
+	branch      int        // branching factor
+	criticality int        // percent of the normal thinking time to spend
+	stopShare   int        // abort after 1/stopShare of the remaining time, 0 for no limit
+	currDepth   int32      // current depth searched
+	stopped     atomicFlag // true to stop the search
+	ponderhit   atomicFlag // true if ponder was successful
 
+	// lock protects the deadlines which are changed
+	// by PonderHit and IterationDone during the search.
+	lock           sync.Mutex
+	startTime      time.Time     // when the search, or pondering, started
 	searchTime     time.Duration // alocated time for this move
+	deadlineStart  time.Time     // when the deadlines were last updated
+	credit         time.Duration // time already searched when the deadlines were updated
 	searchDeadline time.Time     // don't go to the next depth after this deadline
 	stopDeadline   time.Time     // abort search after this deadline
+
+	// Feedback from the search used to scale the time for the next depth.
+	scale     int   // percent of the normal time for the next depth
+	bestMove  Move  // best move of the last iteration
+	bestScore int32 // score of the last iteration
+	stable    int   // number of iterations with the same best move
 }
 
 // NewTimeControl returns a new time control with no time limit,
-// no depth limit, zero time increment and zero moves to go.
+// no depth limit and zero time increment. The number of moves to go,
+// the branching factor and how critical the position is are estimated
+// by the TimeAllocator of the current variant.
 func NewTimeControl(pos *Position, predicted bool) *TimeControl {
-	// Branch more when there are more pieces. With fewer pieces
-	// there is less mobility and hash table kicks in more often.
-	branch := 2
-	for np := (pos.ByColor[White] | pos.ByColor[Black]).Count(); np > 0; np /= 6 {
-		branch++
-	}
-
+	ta := timeAllocator()
 	return &TimeControl{
-		WTime:      infinite,
-		WInc:       0,
-		BTime:      infinite,
-		BInc:       0,
-		Depth:      64,
-		MovesToGo:  defaultMovesToGo,
-		sideToMove: pos.SideToMove,
-		predicted:  predicted,
-		branch:     branch,
+		WTime:       infinite,
+		WInc:        0,
+		BTime:       infinite,
+		BInc:        0,
+		Depth:       64,
+		MovesToGo:   ta.MovesToGo(pos),
+		Clock:       WallClock,
+		sideToMove:  pos.SideToMove,
+		predicted:   predicted,
+		branch:      ta.Branch(pos),
+		criticality: ta.Criticality(pos),
+		stopShare:   ta.StopShare(pos),
 	}
 }
 
@@ -107,6 +124,7 @@ func (tc *TimeControl) thinkingTime() time.Duration {
 	if tc.predicted {
 		tt = tt * 4 / 3
 	}
+	tt = scaleDuration(tt, tc.criticality, 100)
 	if tt < tc.limit {
 		return tt
 	}
@@ -143,32 +161,95 @@ func (tc *TimeControl) Start(ponder bool) {
 	tc.ponderhit = atomicFlag{flag: !ponder}
 
 	tc.searchTime = tc.thinkingTime()
-	tc.updateDeadlines() // deadlines are ignored while pondering (ponderHit == false)
+	tc.startTime = tc.Clock.Now()
+	tc.scale = 100
+	tc.bestMove, tc.stable = NullMove, 0
+	tc.updateDeadlines(0) // deadlines are ignored while pondering (ponderHit == false)
 }
 
-func (tc *TimeControl) updateDeadlines() {
-	now := time.Now()
-	tc.searchDeadline = now.Add(tc.searchTime / time.Duration(tc.branch))
+// updateDeadlines sets the deadlines starting from now.
+// credit is the time already searched which is subtracted
+// from the thinking time, but not from the time limit.
+func (tc *TimeControl) updateDeadlines(credit time.Duration) {
+	tc.deadlineStart, tc.credit = tc.Clock.Now(), credit
+	tc.updateSearchDeadline()
 
 	// stopDeadline is when to abort the search in case of an explosion.
 	// We give a large overhead here so the search is not aborted very often.
-	deadline := tc.searchTime * 4
+	// The TimeAllocator can limit it to a share of the remaining time,
+	// but never to less than the thinking time.
+	next := tc.searchTime / time.Duration(tc.branch)
+	deadline := maxDuration(tc.searchTime*4-credit, next)
+	if tc.stopShare > 0 {
+		if share := maxDuration(tc.time/time.Duration(tc.stopShare), tc.searchTime); deadline > share {
+			deadline = share
+		}
+	}
 	if deadline > tc.limit {
 		deadline = tc.limit
 	}
-	tc.stopDeadline = now.Add(deadline)
+	tc.stopDeadline = tc.deadlineStart.Add(deadline)
+}
+
+// updateSearchDeadline sets the deadline for starting a new depth.
+func (tc *TimeControl) updateSearchDeadline() {
+	next := scaleDuration(tc.searchTime/time.Duration(tc.branch), tc.scale, 100)
+	tc.searchDeadline = tc.deadlineStart.Add(maxDuration(next-tc.credit, 0))
+}
+
+// IterationDone is called by the search after each completed depth
+// with the best move, its score and the number of legal moves at root.
+//
+// The time for the next depth is extended when the best move changed
+// or the score dropped and it is shortened when the best move is stable.
+// With a single legal move there is nothing to think about.
+func (tc *TimeControl) IterationDone(move Move, score int32, numMoves int) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+
+	scale := 100
+	if numMoves <= 1 {
+		scale = 0
+	} else {
+		if move == tc.bestMove {
+			tc.stable++
+		} else if tc.bestMove != NullMove {
+			tc.stable = 0
+			scale += 50 // the best move changed
+		}
+		if tc.stable >= 2 {
+			scale -= 10 * int(min(int32(tc.stable), 5)) // the best move is stable
+		}
+		if tc.bestMove != NullMove {
+			if drop := tc.bestScore - score; drop >= 50 {
+				scale += 100
+			} else if drop >= 20 {
+				scale += 50
+			}
+		}
+	}
+
+	tc.bestMove, tc.bestScore = move, score
+	tc.scale = scale
+	tc.updateSearchDeadline()
 }
 
 // NextDepth returns true if search can start at depth.
 // In any case Stopped() will return false.
 func (tc *TimeControl) NextDepth(depth int32) bool {
 	tc.currDepth = depth
-	return tc.currDepth <= tc.Depth && !tc.hasStopped(tc.searchDeadline)
+	return tc.currDepth <= tc.Depth && !tc.hasStopped(&tc.searchDeadline)
 }
 
 // PonderHit switch to our time control.
+//
+// The search started by pondering continues. The time spent pondering
+// was a search of the current position, so it is credited against
+// the thinking time. Our clock starts now so the time limit is unchanged.
 func (tc *TimeControl) PonderHit() {
-	tc.updateDeadlines()
+	tc.lock.Lock()
+	tc.updateDeadlines(tc.Clock.Now().Sub(tc.startTime))
+	tc.lock.Unlock()
 	tc.ponderhit.set()
 }
 
@@ -177,7 +258,9 @@ func (tc *TimeControl) Stop() {
 	tc.stopped.set()
 }
 
-func (tc *TimeControl) hasStopped(deadline time.Time) bool {
+// hasStopped returns true if the search was stopped or the deadline has passed.
+// The deadline is read under lock, because PonderHit and IterationDone change it.
+func (tc *TimeControl) hasStopped(deadline *time.Time) bool {
 	if tc.currDepth <= 2 {
 		// Run for at few depths at least otherwise mates can be missed.
 		return false
@@ -186,20 +269,40 @@ func (tc *TimeControl) hasStopped(deadline time.Time) bool {
 		// Use a cached value if available.
 		return true
 	}
-	if tc.ponderhit.get() && time.Now().After(deadline) {
-		// Stop search if no longer pondering and deadline as passed.
-		return true
+	if !tc.ponderhit.get() {
+		// Deadlines are ignored while pondering.
+		return false
 	}
-	return false
+	// Stop search if no longer pondering and deadline as passed.
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.Clock.Now().After(*deadline)
 }
 
 // Stopped returns true if the search has stopped because
 // Stop() was called or the time has ran out.
 func (tc *TimeControl) Stopped() bool {
-	if !tc.hasStopped(tc.stopDeadline) {
+	if !tc.hasStopped(&tc.stopDeadline) {
 		return false
 	}
 	// Time has ran out so flip the stopped flag.
 	tc.stopped.set()
 	return true
 }
+
+// scaleDuration returns d*num/den. The division is done first
+// for long durations, e.g. infinite, so the result doesn't overflow.
+func scaleDuration(d time.Duration, num, den int) time.Duration {
+	if num > 0 && d > math.MaxInt64/time.Duration(num) {
+		return d / time.Duration(den) * time.Duration(num)
+	}
+	return d * time.Duration(num) / time.Duration(den)
+}
+
+// maxDuration returns maximum of a and b.
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
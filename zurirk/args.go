@@ -0,0 +1,91 @@
+// args.go implements a tokenizer for the arguments of UCI commands.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uciArgs iterates over the whitespace separated arguments of a command.
+type uciArgs struct {
+	args []string
+	pos  int
+}
+
+// newUCIArgs returns the arguments of line following the command cmd.
+func newUCIArgs(line, cmd string) *uciArgs {
+	line = strings.TrimSpace(line)
+	return &uciArgs{args: strings.Fields(strings.TrimPrefix(line, cmd))}
+}
+
+// done returns true if there are no more arguments.
+func (ua *uciArgs) done() bool {
+	return ua.pos >= len(ua.args)
+}
+
+// peek returns the next argument without consuming it.
+// Returns "" if there are no more arguments.
+func (ua *uciArgs) peek() string {
+	if ua.done() {
+		return ""
+	}
+	return ua.args[ua.pos]
+}
+
+// next consumes and returns the next argument.
+// Returns "" if there are no more arguments.
+func (ua *uciArgs) next() string {
+	arg := ua.peek()
+	if !ua.done() {
+		ua.pos++
+	}
+	return arg
+}
+
+// until consumes and returns the arguments up to, but excluding, keyword.
+func (ua *uciArgs) until(keyword string) []string {
+	start := ua.pos
+	for !ua.done() && ua.peek() != keyword {
+		ua.pos++
+	}
+	return ua.args[start:ua.pos]
+}
+
+// value consumes and returns the value of the parameter name.
+func (ua *uciArgs) value(name string) (string, error) {
+	if ua.done() {
+		return "", fmt.Errorf("missing value for %s", name)
+	}
+	return ua.next(), nil
+}
+
+// int consumes the value of the parameter name and
+// returns it as an integer between min and max.
+func (ua *uciArgs) int(name string, min, max int) (int, error) {
+	str, err := ua.value(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for %s", str, name)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("value %d for %s is out of range [%d, %d]", i, name, min, max)
+	}
+	return i, nil
+}
+
+// millis consumes the value of the parameter name and
+// returns it as a duration in milliseconds.
+func (ua *uciArgs) millis(name string) (time.Duration, error) {
+	// Some GUIs send negative times when the clock has run out.
+	t, err := ua.int(name, -maxMillis, maxMillis)
+	return time.Duration(t) * time.Millisecond, err
+}
+
+// maxMillis is the largest accepted time, about 24 days.
+const maxMillis = 1<<31 - 1
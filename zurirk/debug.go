@@ -0,0 +1,137 @@
+// debug.go implements the debug commands which are handy when
+// testing the engine by hand. They are not part of UCI so they
+// are enabled only by the -debug flag or setoption name Debug.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// debugCommand is a command available in debug mode.
+type debugCommand struct {
+	usage string // the arguments of the command
+	help  string // what the command does
+	run   func(uci *UCI, line string) error
+}
+
+// debugCommands maps the name of each command to its implementation.
+// It is populated in init because help refers to it.
+var debugCommands map[string]debugCommand
+
+var reMakeSanMove = regexp.MustCompile(`^m\s+([^\s]+)$`)
+
+func init() {
+	debugCommands = map[string]debugCommand{
+		"help": {"", "list the debug commands", (*UCI).debugHelp},
+		"f":    {"<fen>", "set the position from fen, same as position fen <fen>", (*UCI).setFEN},
+		"uu":   {"", "print pieces using unicode symbols", (*UCI).useUnicode},
+		"uc":   {"", "print pieces using letters", (*UCI).useLetters},
+		"s":    {"", "switch to standard chess and print the board", (*UCI).setStandard},
+		"r":    {"", "switch to racing kings and print the board", (*UCI).setRacingKings},
+		"p":    {"", "print the board", (*UCI).printBoard},
+		"m":    {"<san>", "make a move given in SAN and print the board", (*UCI).makeSanMove},
+		"d":    {"", "undo the last move and print the board", (*UCI).undoMove},
+		"h":    {"", "print the moves played since the root position", (*UCI).printHistory},
+		"l":    {"", "list the legal moves", (*UCI).listLegalMoves},
+		"vs":   {"", "print the racing kings piece values", (*UCI).printPieceValues},
+		"x":    {"", "quit, same as quit", (*UCI).quit},
+	}
+}
+
+func (uci *UCI) debugHelp(line string) error {
+	var names []string
+	for name := range debugCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dc := debugCommands[name]
+		fmt.Printf("%-4s %-6s %s\n", name, dc.usage, dc.help)
+	}
+	return nil
+}
+
+func (uci *UCI) setFEN(line string) error {
+	return uci.position("position fen " + strings.TrimPrefix(line, "f"))
+}
+
+func (uci *UCI) useUnicode(line string) error {
+	engine.SetUseUnicodeSymbols(true)
+	return nil
+}
+
+func (uci *UCI) useLetters(line string) error {
+	engine.SetUseUnicodeSymbols(false)
+	return nil
+}
+
+func (uci *UCI) setStandard(line string) error {
+	uci.SetVariant(engine.VARIANT_Standard)
+	return uci.printBoard(line)
+}
+
+func (uci *UCI) setRacingKings(line string) error {
+	uci.SetVariant(engine.VARIANT_Racing_Kings)
+	return uci.printBoard(line)
+}
+
+func (uci *UCI) printBoard(line string) error {
+	// Print the board.
+	uci.Engine.PrintBoard()
+	// Print the result if the game is over.
+	if uci.Engine.Position != nil {
+		if result := uci.Engine.Position.Result(); result.Outcome != engine.Ongoing {
+			fmt.Printf("result %v\n", result)
+		}
+	}
+	return nil
+}
+
+func (uci *UCI) makeSanMove(line string) error {
+	option := reMakeSanMove.FindStringSubmatch(line)
+	if option == nil {
+		return fmt.Errorf("invalid make san move arguments")
+	}
+	move, err := uci.Engine.Position.SANToMove(option[1])
+	if err != nil {
+		return fmt.Errorf("invalid move %s: %v", option[1], err)
+	}
+	uci.Engine.DoMove(move)
+	return uci.printBoard(line)
+}
+
+func (uci *UCI) undoMove(line string) error {
+	if len(uci.Engine.Position.History()) == 0 {
+		return fmt.Errorf("no move to delete")
+	}
+	uci.Engine.UndoMove()
+	return uci.printBoard(line)
+}
+
+func (uci *UCI) printHistory(line string) error {
+	pos := uci.Engine.Position
+	fmt.Printf("root %s\n", pos.RootFEN())
+	fmt.Printf("uci %s\n", strings.Join(pos.HistoryUCI(), " "))
+	fmt.Printf("san %s\n", strings.Join(pos.HistorySAN(), " "))
+	return nil
+}
+
+func (uci *UCI) listLegalMoves(line string) error {
+	uci.Engine.Position.PrintLegalMoves()
+	return nil
+}
+
+func (uci *UCI) printPieceValues(line string) error {
+	engine.PrintPieceValues()
+	return nil
+}
+
+func (uci *UCI) quit(line string) error {
+	return errQuit
+}
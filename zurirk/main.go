@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/exec"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/goracingkingsengine/zurirk/engine"
 )
@@ -18,6 +20,14 @@ var (
 
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	version    = flag.Bool("version", false, "only print version and exit")
+	debug      = flag.Bool("debug", false, "enable the debug commands, type help for the list")
+
+	play     = flag.Bool("play", false, "play against the engine from the terminal")
+	side     = flag.String("side", "white", "side played by the human in -play mode, white or black")
+	variant  = flag.String("variant", "racingkings", "variant played in -play mode, standard or racingkings")
+	movetime = flag.Duration("movetime", time.Second, "time the engine thinks for each move in -play mode")
+	fen      = flag.String("fen", "", "start position in -play mode")
+	pgn      = flag.String("pgn", "", "append the game played in -play mode to this file")
 )
 
 func init() {
@@ -51,17 +61,42 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	if *play {
+		if err := runPlayFlags(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "serve" {
+		if err := runServe(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// The protocol is selected by the first command.
+	scan := bufio.NewScanner(os.Stdin)
+	first := ""
+	for first == "" && scan.Scan() {
+		first = strings.TrimSpace(scan.Text())
+	}
+	if isXBoardCommand(first) {
+		if err := runXBoard(first, scan, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.SetOutput(os.Stdout)
 	log.SetPrefix("info string ")
 	log.SetFlags(log.Lshortfile)
 
 	uci := NewUCI()
+	uci.debug = *debug
 
 	uci.SetVariant(engine.VARIANT_CURRENT)
-	
-	scan := bufio.NewScanner(os.Stdin)
-	for scan.Scan() {
-		line := scan.Text()
+
+	for line := first; ; line = scan.Text() {
 		if err := uci.Execute(line); err != nil {
 			if err != errQuit {
 				log.Println(err)
@@ -69,9 +104,34 @@ func main() {
 				break
 			}
 		}
+		if !scan.Scan() {
+			break
+		}
 	}
 
 	if scan.Err() != nil {
 		log.Println(scan.Err())
 	}
 }
+
+// runPlayFlags plays an interactive game with the settings from the command line.
+func runPlayFlags() error {
+	opts := playOptions{MoveTime: *movetime, FEN: *fen, PGNFile: *pgn}
+	switch *side {
+	case "white":
+		opts.Side = engine.White
+	case "black":
+		opts.Side = engine.Black
+	default:
+		return fmt.Errorf("invalid side %s, expected white or black", *side)
+	}
+	switch *variant {
+	case "standard":
+		opts.Variant = engine.VARIANT_Standard
+	case "racingkings":
+		opts.Variant = engine.VARIANT_Racing_Kings
+	default:
+		return fmt.Errorf("invalid variant %s, expected standard or racingkings", *variant)
+	}
+	return runPlay(os.Stdin, os.Stdout, opts)
+}
@@ -0,0 +1,349 @@
+// play.go implements an interactive mode to play against the engine from the terminal.
+//
+// The human enters moves in SAN (Nf3) or UCI (g1f3) notation and the
+// engine replies with its move and score. When the game is over,
+// or the human quits, the game is exported in PGN.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// playOptions are the settings of an interactive game.
+type playOptions struct {
+	Side     engine.Color  // the color played by the human
+	Variant  int           // the variant to play
+	MoveTime time.Duration // time the engine thinks for each move
+	FEN      string        // start position, if empty the variant's start position
+	PGNFile  string        // if not empty the game is also saved to this file
+}
+
+// playLogger remembers the score of the last completed depth.
+type playLogger struct {
+	score int32
+}
+
+func (pl *playLogger) BeginSearch() {
+	pl.score = 0
+}
+
+func (pl *playLogger) EndSearch() {
+}
+
+func (pl *playLogger) PrintPV(stats engine.Stats, score int32, pv []engine.Move) {
+	pl.score = score
+}
+
+// player plays a game between a human and the engine.
+type player struct {
+	opts   playOptions
+	in     *bufio.Scanner
+	out    io.Writer
+	eng    *engine.Engine
+	log    *playLogger
+	result engine.Outcome
+	reason string // why the game ended, empty if decided on the board
+}
+
+// runPlay plays a game reading the human moves from in
+// and writing the board and the engine moves to out.
+func runPlay(in io.Reader, out io.Writer, opts playOptions) error {
+	if opts.Variant != engine.VARIANT_Standard && opts.Variant != engine.VARIANT_Racing_Kings {
+		return fmt.Errorf("unknown variant %d", opts.Variant)
+	}
+	if opts.Side != engine.White && opts.Side != engine.Black {
+		return fmt.Errorf("invalid side %v", opts.Side)
+	}
+	if opts.MoveTime <= 0 {
+		return fmt.Errorf("invalid move time %v", opts.MoveTime)
+	}
+
+	engine.Variant = opts.Variant
+	if opts.FEN == "" {
+		opts.FEN = engine.START_FENS[opts.Variant]
+	}
+	pos, err := engine.PositionFromFEN(opts.FEN)
+	if err != nil {
+		return err
+	}
+	if err := checkKings(pos); err != nil {
+		return err
+	}
+
+	pl := &player{
+		opts: opts,
+		in:   bufio.NewScanner(in),
+		out:  out,
+		log:  &playLogger{},
+	}
+	pl.eng = engine.NewEngine(pos, pl.log, engine.Options{})
+	engine.GlobalHashTable.Clear()
+
+	fmt.Fprintf(out, "You play %v. Type 'help' for the list of commands.\n", colorName(opts.Side))
+	pl.printBoard()
+	pl.loop()
+	return pl.exportPGN()
+}
+
+// loop alternates the human and the engine moves until the game is over.
+func (pl *player) loop() {
+	for {
+		pos := pl.eng.Position
+		if result := pos.Result(); result.Outcome != engine.Ongoing {
+			pl.result = result.Outcome
+			fmt.Fprintf(pl.out, "Game over: %v\n", result)
+			return
+		}
+
+		if pos.SideToMove != pl.opts.Side {
+			pl.engineMove()
+			continue
+		}
+
+		fmt.Fprintf(pl.out, "%s> ", moveNumber(pos))
+		if !pl.in.Scan() {
+			pl.reason = "the game was abandoned"
+			return
+		}
+		if done := pl.execute(strings.TrimSpace(pl.in.Text())); done {
+			return
+		}
+	}
+}
+
+// execute runs a command or plays a move.
+// Returns true if the game has ended.
+func (pl *player) execute(line string) bool {
+	pos := pl.eng.Position
+	switch line {
+	case "":
+		return false
+	case "help":
+		fmt.Fprintf(pl.out, "Enter a move in SAN (Nf3) or UCI (g1f3) notation, or one of the commands:\n")
+		fmt.Fprintf(pl.out, "  board     show the board\n")
+		fmt.Fprintf(pl.out, "  moves     list the legal moves\n")
+		fmt.Fprintf(pl.out, "  hint      ask the engine for a move\n")
+		fmt.Fprintf(pl.out, "  undo      take back your last move\n")
+		fmt.Fprintf(pl.out, "  pgn       show the game so far\n")
+		fmt.Fprintf(pl.out, "  resign    resign the game\n")
+		fmt.Fprintf(pl.out, "  quit      stop playing\n")
+	case "board":
+		pl.printBoard()
+	case "moves":
+		var sans []string
+		for _, m := range pos.GetLegalMoves(engine.GET_ALL) {
+			sans = append(sans, pos.MoveToSAN(m))
+		}
+		fmt.Fprintf(pl.out, "%s\n", strings.Join(sans, " "))
+	case "hint":
+		if move, score, ok := pl.search(); ok {
+			fmt.Fprintf(pl.out, "Hint: %s (%s)\n", pos.MoveToSAN(move), formatScore(score))
+		}
+	case "undo", "takeback":
+		pl.takeback()
+	case "pgn":
+		fmt.Fprint(pl.out, pl.pgn())
+	case "resign":
+		pl.result = winner(pl.opts.Side.Opposite())
+		pl.reason = colorName(pl.opts.Side) + " resigns"
+		fmt.Fprintf(pl.out, "Game over: %v {%s}\n", pl.result, pl.reason)
+		return true
+	case "quit", "exit":
+		pl.reason = "the game was abandoned"
+		return true
+	default:
+		move, err := parseMove(pos, line)
+		if err != nil {
+			fmt.Fprintf(pl.out, "%v\n", err)
+			return false
+		}
+		pl.doMove(move)
+	}
+	return false
+}
+
+// engineMove searches and plays the engine's move.
+func (pl *player) engineMove() {
+	move, score, ok := pl.search()
+	if !ok {
+		return
+	}
+	pos := pl.eng.Position
+	fmt.Fprintf(pl.out, "%s %s (%s)\n", moveNumber(pos), pos.MoveToSAN(move), formatScore(score))
+	pl.doMove(move)
+}
+
+// search returns the best move in the current position and
+// its score from the point of view of the side to move.
+func (pl *player) search() (engine.Move, int32, bool) {
+	pos := pl.eng.Position
+	tc := engine.NewDeadlineTimeControl(pos, pl.opts.MoveTime)
+	tc.Start(false)
+	moves := pl.eng.Play(tc)
+	if len(moves) == 0 {
+		fmt.Fprintf(pl.out, "no move found\n")
+		return engine.NullMove, 0, false
+	}
+	return moves[0], pl.log.score, true
+}
+
+// doMove plays move and shows the new board.
+func (pl *player) doMove(move engine.Move) {
+	pl.eng.DoMove(move)
+	pl.printBoard()
+}
+
+// takeback undoes the human's last move and the engine's reply.
+// The human is to move, so the last move, if any, is the engine's.
+func (pl *player) takeback() {
+	if len(pl.eng.Position.History()) < 2 {
+		fmt.Fprintf(pl.out, "no move to take back\n")
+		return
+	}
+	pl.eng.UndoMove()
+	pl.eng.UndoMove()
+	pl.printBoard()
+}
+
+// printBoard prints the board from the human's side.
+func (pl *player) printBoard() {
+	pos := pl.eng.Position
+	pos.Render(pl.out, engine.RenderOptions{
+		Coordinates: true,
+		Flip:        pl.opts.Side == engine.Black,
+		GoalRank:    pl.opts.Variant == engine.VARIANT_Racing_Kings,
+	})
+	fmt.Fprintf(pl.out, "%v to move\n", colorName(pos.SideToMove))
+}
+
+// pgn returns the game in PGN format.
+func (pl *player) pgn() string {
+	names := map[engine.Color]string{
+		pl.opts.Side:            "Human",
+		pl.opts.Side.Opposite(): "zurirk",
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "[Event \"Interactive game\"]\n")
+	fmt.Fprintf(buf, "[Site \"?\"]\n")
+	fmt.Fprintf(buf, "[Date \"%s\"]\n", time.Now().Format("2006.01.02"))
+	fmt.Fprintf(buf, "[Round \"-\"]\n")
+	fmt.Fprintf(buf, "[White \"%s\"]\n", names[engine.White])
+	fmt.Fprintf(buf, "[Black \"%s\"]\n", names[engine.Black])
+	fmt.Fprintf(buf, "[Result \"%v\"]\n", pl.result)
+	if pl.opts.Variant == engine.VARIANT_Racing_Kings {
+		fmt.Fprintf(buf, "[Variant \"Racing Kings\"]\n")
+	}
+	if root := pl.eng.Position.RootFEN(); root != engine.FENStartPos {
+		fmt.Fprintf(buf, "[SetUp \"1\"]\n")
+		fmt.Fprintf(buf, "[FEN \"%s\"]\n", root)
+	}
+	fmt.Fprintf(buf, "\n")
+
+	// Replay the game from the start position to number the moves.
+	game := pl.eng.Position
+	pos, _ := game.Replay(0)
+	sans := game.HistorySAN()
+	var tokens []string
+	for i, m := range game.History() {
+		if pos.SideToMove == engine.White || i == 0 {
+			tokens = append(tokens, moveNumber(pos))
+		}
+		tokens = append(tokens, sans[i])
+		pos.DoMove(m)
+	}
+	if pl.reason != "" {
+		tokens = append(tokens, "{"+pl.reason+"}")
+	}
+	tokens = append(tokens, pl.result.String())
+
+	// Wrap the movetext at 80 columns.
+	line := 0
+	for i, t := range tokens {
+		if i > 0 && line+1+len(t) > 80 {
+			fmt.Fprintf(buf, "\n")
+			line = 0
+		} else if i > 0 {
+			fmt.Fprintf(buf, " ")
+			line++
+		}
+		fmt.Fprintf(buf, "%s", t)
+		line += len(t)
+	}
+	fmt.Fprintf(buf, "\n")
+	return buf.String()
+}
+
+// exportPGN prints the game and saves it to PGNFile if requested.
+func (pl *player) exportPGN() error {
+	pgn := pl.pgn()
+	fmt.Fprintf(pl.out, "\n%s", pgn)
+	if pl.opts.PGNFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(pl.opts.PGNFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", pgn); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseMove parses a legal move in UCI or SAN notation.
+func parseMove(pos *engine.Position, str string) (engine.Move, error) {
+	if move, err := pos.UCIToMove(str); err == nil {
+		return move, nil
+	}
+	move, err := pos.SANToMove(str)
+	if err != nil {
+		return engine.NullMove, fmt.Errorf("cannot parse move %s: %v", str, err)
+	}
+	return move, nil
+}
+
+// moveNumber returns the move number in PGN notation, e.g. "12." or "12...".
+func moveNumber(pos *engine.Position) string {
+	number := strings.Fields(pos.String())[5]
+	if pos.SideToMove == engine.White {
+		return number + "."
+	}
+	return number + "..."
+}
+
+// formatScore formats a score given in centipawns.
+func formatScore(score int32) string {
+	if score > engine.KnownWinScore {
+		return fmt.Sprintf("mate in %d", (engine.MateScore-score+1)/2)
+	}
+	if score < engine.KnownLossScore {
+		return fmt.Sprintf("mated in %d", (score-engine.MatedScore)/2)
+	}
+	return fmt.Sprintf("score %+.2f", float64(score)/100)
+}
+
+// colorName returns the name of the color col.
+func colorName(col engine.Color) string {
+	if col == engine.White {
+		return "White"
+	}
+	return "Black"
+}
+
+// winner returns the outcome when col wins.
+func winner(col engine.Color) engine.Outcome {
+	if col == engine.White {
+		return engine.WhiteWins
+	}
+	return engine.BlackWins
+}
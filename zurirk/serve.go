@@ -0,0 +1,376 @@
+// serve.go implements an HTTP server answering analysis requests in JSON.
+//
+// All endpoints read the position from the fen parameter, the start
+// position of the variant if missing, followed by the moves parameter,
+// a space separated list of moves in UCI or SAN notation.
+//
+//   /legal     the legal moves
+//   /eval      the static evaluation
+//   /bestmove  the best move and the principal variation; the search
+//              is limited by depth and by movetime in milliseconds
+//   /perft     the number of leaf nodes at depth
+//   /validate  whether the position is valid
+//
+// Scores are in centipawns from the side to move's point of view.
+// Searches are run by a pool of engines and they stop early when
+// the client cancels the request.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+const (
+	defaultMoveTime = 1000  // milliseconds searched if no limit is given
+	maxMoveTime     = 60000 // maximum movetime in milliseconds
+	maxSearchDepth  = 63    // maximum search depth
+	maxPerftDepth   = 7     // maximum perft depth
+)
+
+// moveJSON is a move in both notations.
+type moveJSON struct {
+	UCI string `json:"uci"`
+	SAN string `json:"san"`
+}
+
+type legalResponse struct {
+	FEN   string     `json:"fen"`
+	Moves []moveJSON `json:"moves"`
+}
+
+type evalResponse struct {
+	FEN   string `json:"fen"`
+	Score int32  `json:"score"`
+}
+
+type bestMoveResponse struct {
+	FEN      string     `json:"fen"`
+	BestMove *moveJSON  `json:"bestmove"` // nil if the game has ended
+	Score    int32      `json:"score"`
+	Mate     int32      `json:"mate,omitempty"` // moves to mate, negative if mated
+	Depth    int32      `json:"depth"`
+	Nodes    uint64     `json:"nodes"`
+	PV       []moveJSON `json:"pv"`
+}
+
+type perftResponse struct {
+	FEN   string `json:"fen"`
+	Depth int    `json:"depth"`
+	Nodes uint64 `json:"nodes"`
+}
+
+type validateResponse struct {
+	Valid bool   `json:"valid"`
+	FEN   string `json:"fen,omitempty"`   // the normalized position if valid
+	Error string `json:"error,omitempty"` // why the position is invalid
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError is an error returned to the client with the HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (he *httpError) Error() string {
+	return he.err.Error()
+}
+
+// badRequest returns an error for invalid request parameters.
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// server answers the analysis requests.
+type server struct {
+	engines chan *engine.Engine // the idle engines
+	mux     *http.ServeMux
+}
+
+// newServer returns a server with a pool of n engines.
+// Each engine has its own transposition table of hashSizeMB megabytes.
+func newServer(n, hashSizeMB int) *server {
+	s := &server{
+		engines: make(chan *engine.Engine, n),
+		mux:     http.NewServeMux(),
+	}
+	for i := 0; i < n; i++ {
+		options := engine.Options{HashTable: engine.NewHashTable(hashSizeMB)}
+		s.engines <- engine.NewEngine(nil, nil, options)
+	}
+
+	s.mux.HandleFunc("/legal", s.handle(s.legal))
+	s.mux.HandleFunc("/eval", s.handle(s.eval))
+	s.mux.HandleFunc("/bestmove", s.handle(s.bestMove))
+	s.mux.HandleFunc("/perft", s.handle(s.perft))
+	s.mux.HandleFunc("/validate", s.handle(s.validate))
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle converts h to an http.HandlerFunc writing the response
+// or the error in JSON.
+func (s *server) handle(h func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+
+		resp, err := h(r)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if he, ok := err.(*httpError); ok {
+				code = he.code
+			}
+			writeJSON(w, code, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// writeJSON writes v as the response body.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// get takes an idle engine from the pool waiting until one is available.
+// Returns an error if the request is cancelled meanwhile.
+func (s *server) get(ctx context.Context) (*engine.Engine, error) {
+	select {
+	case eng := <-s.engines:
+		return eng, nil
+	case <-ctx.Done():
+		return nil, &httpError{http.StatusServiceUnavailable, fmt.Errorf("no engine available: %v", ctx.Err())}
+	}
+}
+
+// put returns eng to the pool.
+func (s *server) put(eng *engine.Engine) {
+	s.engines <- eng
+}
+
+func (s *server) legal(r *http.Request) (interface{}, error) {
+	pos, err := requestPosition(r)
+	if err != nil {
+		return nil, err
+	}
+	resp := legalResponse{FEN: pos.String(), Moves: []moveJSON{}}
+	for _, m := range pos.GetLegalMoves(engine.GET_ALL) {
+		resp.Moves = append(resp.Moves, moveJSON{m.UCI(), pos.MoveToSAN(m)})
+	}
+	return resp, nil
+}
+
+func (s *server) eval(r *http.Request) (interface{}, error) {
+	pos, err := requestPosition(r)
+	if err != nil {
+		return nil, err
+	}
+	score := engine.ScaleToCentiPawn(engine.Evaluate(pos))
+	if pos.SideToMove == engine.Black {
+		score = -score
+	}
+	return evalResponse{FEN: pos.String(), Score: score}, nil
+}
+
+func (s *server) bestMove(r *http.Request) (interface{}, error) {
+	pos, err := requestPosition(r)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := intParam(r, "depth", 0, maxSearchDepth)
+	if err != nil {
+		return nil, err
+	}
+	movetime, err := intParam(r, "movetime", 0, maxMoveTime)
+	if err != nil {
+		return nil, err
+	}
+	if depth == 0 && movetime == 0 {
+		movetime = defaultMoveTime
+	}
+
+	eng, err := s.get(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(eng)
+
+	// The search stops early if the client goes away.
+	eng.SetPosition(pos)
+	limits := engine.Limits{
+		Depth:    int32(depth),
+		MoveTime: time.Duration(movetime) * time.Millisecond,
+	}
+	var result engine.AnalysisUpdate
+	for result = range eng.Analyze(r.Context(), limits) {
+		// The last update holds the search result.
+	}
+
+	resp := bestMoveResponse{
+		FEN:   pos.String(),
+		Mate:  result.Mate,
+		Depth: result.Depth,
+		Nodes: result.Nodes,
+		PV:    pvJSON(pos, result.PV),
+	}
+	if len(resp.PV) != 0 {
+		resp.BestMove = &resp.PV[0]
+	}
+	if result.Mate == 0 {
+		resp.Score = result.Score
+	}
+	return resp, nil
+}
+
+func (s *server) perft(r *http.Request) (interface{}, error) {
+	pos, err := requestPosition(r)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := intParam(r, "depth", 1, maxPerftDepth)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := perft(r.Context(), pos, depth)
+	if err != nil {
+		return nil, &httpError{http.StatusServiceUnavailable, err}
+	}
+	return perftResponse{FEN: pos.String(), Depth: depth, Nodes: nodes}, nil
+}
+
+func (s *server) validate(r *http.Request) (interface{}, error) {
+	pos, err := requestPosition(r)
+	if err != nil {
+		return validateResponse{Error: err.Error()}, nil
+	}
+	return validateResponse{Valid: true, FEN: pos.String()}, nil
+}
+
+// requestPosition returns the position described by the fen and moves parameters.
+func requestPosition(r *http.Request) (*engine.Position, error) {
+	fen := strings.TrimSpace(r.FormValue("fen"))
+	if fen == "" {
+		fen = engine.START_FENS[engine.Variant]
+	}
+	pos, err := engine.PositionFromFEN(fen)
+	if err != nil {
+		return nil, badRequest("invalid fen: %v", err)
+	}
+	if err := checkKings(pos); err != nil {
+		return nil, badRequest("invalid fen: %v", err)
+	}
+	for _, str := range strings.Fields(r.FormValue("moves")) {
+		m, err := parseMove(pos, str)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		pos.DoMove(m)
+	}
+	return pos, nil
+}
+
+// intParam returns the integer parameter name between min and max,
+// or min if the parameter is missing.
+func intParam(r *http.Request, name string, min, max int) (int, error) {
+	str := r.FormValue(name)
+	if str == "" {
+		return min, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < min || n > max {
+		return 0, badRequest("invalid %s %q, expected a number between %d and %d", name, str, min, max)
+	}
+	return n, nil
+}
+
+// pvJSON converts the principal variation starting at pos to both notations.
+func pvJSON(pos *engine.Position, moves []engine.Move) []moveJSON {
+	pv := make([]moveJSON, 0, len(moves))
+	for _, m := range moves {
+		pv = append(pv, moveJSON{m.UCI(), pos.MoveToSAN(m)})
+		pos.DoMove(m)
+	}
+	for range moves {
+		pos.UndoMove()
+	}
+	return pv
+}
+
+// perft returns the number of leaf nodes at depth.
+// Returns an error if ctx is done before the count finishes.
+func perft(ctx context.Context, pos *engine.Position, depth int) (uint64, error) {
+	moves := pos.GetLegalMoves(engine.GET_ALL)
+	if depth <= 1 {
+		return uint64(len(moves)), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	nodes := uint64(0)
+	for _, m := range moves {
+		pos.DoMove(m)
+		n, err := perft(ctx, pos, depth-1)
+		pos.UndoMove()
+		if err != nil {
+			return 0, err
+		}
+		nodes += n
+	}
+	return nodes, nil
+}
+
+// runServe parses the serve command line and serves the analysis requests.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	variant := fs.String("variant", "racingkings", "variant analyzed, standard or racingkings")
+	engines := fs.Int("engines", 2, "number of engines searching concurrently")
+	hash := fs.Int("hash", engine.DefaultHashTableSizeMB, "size of each engine's transposition table in MB")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch *variant {
+	case "standard":
+		engine.Variant = engine.VARIANT_Standard
+	case "racingkings":
+		engine.Variant = engine.VARIANT_Racing_Kings
+	default:
+		return fmt.Errorf("invalid variant %s, expected standard or racingkings", *variant)
+	}
+	if *engines < 1 {
+		return fmt.Errorf("invalid number of engines %d", *engines)
+	}
+	if *hash < 1 || *hash > 65536 {
+		return fmt.Errorf("invalid hash size %d", *hash)
+	}
+
+	log.Printf("serving %s on %s with %d engines", *variant, *addr, *engines)
+	return http.ListenAndServe(*addr, newServer(*engines, *hash))
+}
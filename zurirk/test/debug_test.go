@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+func TestDebugCommandsDisabled(t *testing.T) {
+	defer saveGlobals()()
+	defer silence(t)()
+
+	uci := NewUCI()
+	for name := range debugCommands {
+		if err := uci.Execute(name); err == nil || err == errQuit {
+			t.Errorf("%s: expected unhandled command, got %v", name, err)
+		}
+	}
+}
+
+func TestDebugCommands(t *testing.T) {
+	defer saveGlobals()()
+	defer silence(t)()
+	engine.Variant = engine.VARIANT_Racing_Kings
+
+	uci := NewUCI()
+	if err := uci.Execute("setoption name Debug value true"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for i, line := range []string{"help", "uc", "p", "l", "vs", "s", "m e4", "h", "d", "r", "f 8/6K1/1k6/8/8/8/8/8 w - - 0 1"} {
+		if err := uci.Execute(line); err != nil {
+			t.Errorf("#%d %s: unexpected error %v", i, line, err)
+		}
+	}
+	if fen := uci.Engine.Position.String(); fen != "8/6K1/1k6/8/8/8/8/8 w - - 0 1" {
+		t.Errorf("f: expected position to be set, got %s", fen)
+	}
+
+	// Errors are returned so they are printed as info string.
+	for i, line := range []string{"m", "m Qh5", "m Kg9", "d", "f 8/8/8 w"} {
+		if err := uci.Execute(line); err == nil {
+			t.Errorf("#%d %s: expected error", i, line)
+		}
+	}
+	if err := uci.Execute("x"); err != errQuit {
+		t.Errorf("x: expected quit, got %v", err)
+	}
+
+	if err := uci.Execute("setoption name Debug value false"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := uci.Execute("p"); err == nil {
+		t.Errorf("p: expected error after disabling debug commands")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// playScript plays a game with the human input in script and returns the output.
+func playScript(t *testing.T, opts playOptions, script ...string) string {
+	out := &bytes.Buffer{}
+	in := strings.NewReader(strings.Join(script, "\n") + "\n")
+	if err := runPlay(in, out, opts); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return out.String()
+}
+
+func TestPlayRacingKingsGameOver(t *testing.T) {
+	defer saveGlobals()()
+
+	opts := playOptions{
+		Side:     engine.White,
+		Variant:  engine.VARIANT_Racing_Kings,
+		MoveTime: 50 * time.Millisecond,
+		FEN:      "8/6K1/1k6/8/8/8/8/8 w - - 0 1",
+	}
+	out := playScript(t, opts, "Kb8", "hello", "g7g8")
+
+	for _, expected := range []string{
+		"move Kb8",
+		"cannot parse move hello",
+		"Game over: 1-0 {king reached the 8th rank}",
+		`[Result "1-0"]`,
+		`[Variant "Racing Kings"]`,
+		`[FEN "8/6K1/1k6/8/8/8/8/8 w - - 0 1"]`,
+		"1. Kg8 1-0",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestPlayCommands(t *testing.T) {
+	defer saveGlobals()()
+
+	opts := playOptions{
+		Side:     engine.Black,
+		Variant:  engine.VARIANT_Standard,
+		MoveTime: 20 * time.Millisecond,
+	}
+	out := playScript(t, opts, "undo", "hint", "moves", "e7e5", "undo", "Nf6", "quit")
+
+	for _, expected := range []string{
+		"1. ", // the engine moves first
+		"no move to take back",
+		"Hint: ",
+		"Nh6",
+		`[White "zurirk"]`,
+		`[Black "Human"]`,
+		`[Result "*"]`,
+		" Nf6 2. ",
+		"{the game was abandoned} *",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "e5 2.") {
+		t.Errorf("e7e5 was not taken back:\n%s", out)
+	}
+}
+
+func TestPlayResign(t *testing.T) {
+	defer saveGlobals()()
+
+	opts := playOptions{
+		Side:     engine.White,
+		Variant:  engine.VARIANT_Racing_Kings,
+		MoveTime: 20 * time.Millisecond,
+	}
+	out := playScript(t, opts, "resign")
+	if !strings.Contains(out, "{White resigns} 0-1") {
+		t.Errorf("expected resignation in output:\n%s", out)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// captureBestMove redirects stdout and returns a channel receiving
+// the bestmove lines and a function to restore stdout.
+func captureBestMove(t *testing.T) (<-chan string, func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	bestmove := make(chan string, 16)
+	go func() {
+		scan := bufio.NewScanner(r)
+		for scan.Scan() {
+			if line := scan.Text(); strings.HasPrefix(line, "bestmove") {
+				bestmove <- line
+			}
+		}
+		close(bestmove)
+	}()
+
+	return bestmove, func() {
+		os.Stdout = stdout
+		w.Close()
+	}
+}
+
+// Tests that after ponderhit the search started by go ponder
+// continues and the best move arrives within the time budget.
+func TestPonderHit(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	bestmove, restore := captureBestMove(t)
+	defer restore()
+
+	// The search sees the time passing only when the clock is advanced.
+	clock := engine.NewFakeClock(time.Now())
+	uci := NewUCI()
+	uci.clock = clock
+	for _, line := range []string{
+		"position startpos moves h2h3",
+		"go ponder wtime 3000 btime 3000",
+	} {
+		if err := uci.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	// No move is reported while pondering, even if the ponder
+	// time is larger than the time available for the move.
+	clock.Advance(1200 * time.Millisecond)
+	select {
+	case line := <-bestmove:
+		t.Fatalf("got %q while pondering", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := uci.Execute("ponderhit"); err != nil {
+		t.Fatalf("ponderhit: %v", err)
+	}
+
+	// The thinking time is 3000ms/12 estimated moves to go = 250ms.
+	// The ponder time is credited so no new depth is started once the
+	// clock moves, otherwise the search would continue until the clock
+	// is advanced by a fraction of 250ms.
+	clock.Advance(time.Millisecond)
+	select {
+	case line := <-bestmove:
+		if !strings.HasPrefix(line, "bestmove ") || strings.Contains(line, "(none)") {
+			t.Errorf("expected a move, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no bestmove after ponderhit, expected the ponder time to be credited")
+	}
+
+	// The engine is ready for the next command.
+	if err := uci.Execute("isready"); err != nil {
+		t.Errorf("isready: %v", err)
+	}
+}
+
+// Tests that stop ends pondering on a ponder miss and
+// that a new search can start after it.
+func TestPonderMiss(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	bestmove, restore := captureBestMove(t)
+	defer restore()
+
+	uci := NewUCI()
+	for _, line := range []string{
+		"position startpos moves h2h3",
+		"go ponder wtime 1000 btime 1000",
+		"stop",
+	} {
+		if err := uci.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	<-bestmove
+
+	for _, line := range []string{
+		"position startpos moves h2h3 a2a3",
+		"go depth 3",
+		"isready",
+	} {
+		if err := uci.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	select {
+	case <-bestmove:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no bestmove after ponder miss")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// serveRequest sends a GET request to s and decodes the JSON response in v.
+func serveRequest(t *testing.T, s http.Handler, ctx context.Context, path string, params url.Values, v interface{}) int {
+	req := httptest.NewRequest("GET", path+"?"+params.Encode(), nil)
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: expected application/json, got %s", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: cannot decode %s: %v", path, rec.Body, err)
+	}
+	return rec.Code
+}
+
+func TestServeLegal(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	var resp legalResponse
+	if code := serveRequest(t, s, nil, "/legal", nil, &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.FEN != engine.START_FENS[engine.VARIANT_Racing_Kings] {
+		t.Errorf("expected the start position, got %s", resp.FEN)
+	}
+	if len(resp.Moves) != 21 {
+		t.Errorf("expected 21 moves, got %d", len(resp.Moves))
+	}
+	found := false
+	for _, m := range resp.Moves {
+		found = found || m == moveJSON{"h2h3", "Kh3"}
+	}
+	if !found {
+		t.Errorf("expected h2h3 (Kh3) in %v", resp.Moves)
+	}
+
+	// The moves are played from the position.
+	params := url.Values{"moves": {"h2h3 Kb3"}}
+	if code := serveRequest(t, s, nil, "/legal", params, &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if expected := "8/8/8/8/8/1k5K/1rbnNBR1/qrbnNBRQ w - - 2 2"; resp.FEN != expected {
+		t.Errorf("expected %s, got %s", expected, resp.FEN)
+	}
+}
+
+func TestServeEval(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	// The score is from the side to move's POV.
+	var white, black evalResponse
+	serveRequest(t, s, nil, "/eval", url.Values{"fen": {"8/8/8/8/8/8/K7/6k1 w - - 0 1"}}, &white)
+	serveRequest(t, s, nil, "/eval", url.Values{"fen": {"8/8/8/8/8/8/K7/6k1 b - - 0 1"}}, &black)
+	if white.Score <= 0 || white.Score != -black.Score {
+		t.Errorf("expected opposite scores favoring White, got %d and %d", white.Score, black.Score)
+	}
+}
+
+func TestServeBestMove(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	var resp bestMoveResponse
+	params := url.Values{"depth": {"3"}}
+	if code := serveRequest(t, s, nil, "/bestmove", params, &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.BestMove == nil || len(resp.PV) == 0 || *resp.BestMove != resp.PV[0] {
+		t.Fatalf("expected the best move to start the pv, got %v and %v", resp.BestMove, resp.PV)
+	}
+	if resp.Depth != 3 || resp.Nodes == 0 {
+		t.Errorf("expected depth 3 and some nodes, got %d and %d", resp.Depth, resp.Nodes)
+	}
+
+	// The pv is legal and SAN matches UCI.
+	pos, _ := engine.PositionFromFEN(resp.FEN)
+	for _, m := range resp.PV {
+		move := uciMove(t, pos, m.UCI)
+		if san := pos.MoveToSAN(move); san != m.SAN {
+			t.Errorf("expected %s for %s, got %s", san, m.UCI, m.SAN)
+		}
+		pos.DoMove(move)
+	}
+
+	// Black wins by moving the king to the 8th rank.
+	params = url.Values{"fen": {"8/1k6/8/8/8/8/8/6K1 b - - 0 1"}, "movetime": {"100"}}
+	if code := serveRequest(t, s, nil, "/bestmove", params, &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.BestMove == nil || resp.BestMove.SAN[:2] != "K"+resp.BestMove.UCI[2:3] || resp.BestMove.UCI[3] != '8' {
+		t.Errorf("expected the king to reach the 8th rank, got %v", resp.BestMove)
+	}
+	if resp.Mate != 1 {
+		t.Errorf("expected mate in 1, got %d", resp.Mate)
+	}
+}
+
+func TestServePerft(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	for depth, nodes := range []uint64{21, 421, 11264} {
+		var resp perftResponse
+		params := url.Values{"depth": {string('1' + rune(depth))}}
+		if code := serveRequest(t, s, nil, "/perft", params, &resp); code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+		if resp.Nodes != nodes {
+			t.Errorf("expected %d nodes at depth %d, got %d", nodes, depth+1, resp.Nodes)
+		}
+	}
+}
+
+func TestServeValidate(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	var resp validateResponse
+	params := url.Values{"fen": {" 8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1 "}}
+	if code := serveRequest(t, s, nil, "/validate", params, &resp); code != http.StatusOK || !resp.Valid {
+		t.Errorf("expected a valid position, got %d %+v", code, resp)
+	}
+	if expected := "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1"; resp.FEN != expected {
+		t.Errorf("expected %s, got %s", expected, resp.FEN)
+	}
+
+	for _, fen := range []string{"8/8/8/8 w - - 0 1", "8/8/8/8/8/8/8/8 w - - 0 1", "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ x - - 0 1"} {
+		resp = validateResponse{}
+		if code := serveRequest(t, s, nil, "/validate", url.Values{"fen": {fen}}, &resp); code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", fen, code)
+		}
+		if resp.Valid || resp.Error == "" {
+			t.Errorf("%s: expected an invalid position, got %+v", fen, resp)
+		}
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	for _, d := range []struct {
+		path   string
+		params url.Values
+	}{
+		{"/legal", url.Values{"fen": {"foo"}}},
+		{"/legal", url.Values{"moves": {"e2e4"}}},
+		{"/eval", url.Values{"fen": {"8/8/8/8/8/8/8/8 w - - 0 1"}}},
+		{"/bestmove", url.Values{"depth": {"x"}}},
+		{"/bestmove", url.Values{"movetime": {"-1"}}},
+		{"/perft", url.Values{"depth": {"0"}}},
+		{"/perft", url.Values{"depth": {"100"}}},
+	} {
+		var resp errorResponse
+		if code := serveRequest(t, s, nil, d.path, d.params, &resp); code != http.StatusBadRequest {
+			t.Errorf("%s %v: expected status 400, got %d", d.path, d.params, code)
+		}
+		if resp.Error == "" {
+			t.Errorf("%s %v: expected an error", d.path, d.params)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("DELETE", "/legal", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	s := newServer(1, 1)
+
+	// A long search stops when the request is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var resp bestMoveResponse
+	params := url.Values{"movetime": {"60000"}}
+	if code := serveRequest(t, s, ctx, "/bestmove", params, &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the search to stop, took %v", elapsed)
+	}
+	if resp.BestMove == nil {
+		t.Errorf("expected a move from the cancelled search")
+	}
+
+	// A request waiting for an engine gives up when cancelled.
+	eng := <-s.engines
+	var errResp errorResponse
+	if code := serveRequest(t, s, ctx, "/bestmove", nil, &errResp); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", code)
+	}
+	s.engines <- eng
+
+	// And so does perft.
+	if code := serveRequest(t, s, ctx, "/perft", url.Values{"depth": {"7"}}, &errResp); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", code)
+	}
+}
+
+func TestServeConcurrent(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	ts := httptest.NewServer(newServer(2, 1))
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := http.Get(ts.URL + "/bestmove?depth=3")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer r.Body.Close()
+			var resp bestMoveResponse
+			if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+				t.Error(err)
+			} else if r.StatusCode != http.StatusOK || resp.BestMove == nil {
+				t.Errorf("expected a move, got %d %+v", r.StatusCode, resp)
+			}
+		}()
+	}
+	wg.Wait()
+}
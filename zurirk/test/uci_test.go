@@ -0,0 +1,344 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// silence redirects stdout to /dev/null and returns a function to restore it.
+func silence(t *testing.T) func() {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = null
+	return func() {
+		os.Stdout = stdout
+		null.Close()
+	}
+}
+
+// saveGlobals saves the engine settings changed by UCI commands
+// and returns a function to restore them.
+func saveGlobals() func() {
+	variant := engine.Variant
+	values := append([]int32{}, engine.RK_PIECE_VALUES...)
+	kingAdvance := engine.KING_ADVANCE_VALUE
+	hash := engine.GlobalHashTable
+	chess960 := engine.Chess960
+	return func() {
+		engine.Variant = variant
+		engine.Chess960 = chess960
+		copy(engine.RK_PIECE_VALUES, values)
+		engine.KING_ADVANCE_VALUE = kingAdvance
+		engine.GlobalHashTable = hash
+	}
+}
+
+func TestPositionFENOptionalFields(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Standard
+
+	data := []struct {
+		line string
+		fen  string
+	}{
+		{"position fen 8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - -",
+			"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1"},
+		{"position fen 8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 7",
+			"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 7 1"},
+		{"position fen 8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 3 12",
+			"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 3 12"},
+		{"position fen 4k3/8/8/8/8/8/8/4K3 w - - moves e1e2",
+			"4k3/8/8/8/8/8/4K3/8 b - - 1 1"},
+		{"position   startpos   moves  e2e4 ",
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"},
+	}
+
+	uci := NewUCI()
+	for i, d := range data {
+		if err := uci.Execute(d.line); err != nil {
+			t.Errorf("#%d %s: unexpected error %v", i, d.line, err)
+			continue
+		}
+		if fen := uci.Engine.Position.String(); fen != d.fen {
+			t.Errorf("#%d %s: expected fen %s, got %s", i, d.line, d.fen, fen)
+		}
+	}
+}
+
+func TestMalformedCommands(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Standard
+
+	for i, line := range []string{
+		"position",
+		"position fen",
+		"position fen 8/8/8 w",
+		"position fen 4k3/8/8/8/8/8/8/4K3 w - - 0 1 2",
+		"position fen 4k3/8/8/8/8/8/8/4K3 x - - 0 1",
+		"position fen 4k3/8/8/8/8/8/8/8 w - - 0 1",
+		"position fen 4k3/8/8/8/8/8/8/4K3 w - - zero 1",
+		"position startpos foo",
+		"position startpos moves e2e5",
+		"position startpos moves e2e4 e2e4",
+		"position startpos moves e2",
+		"position fen 4k3/4r3/8/8/8/8/8/3QK3 w - - moves d1d2",
+		"position random",
+		"go depth",
+		"go depth abc",
+		"go depth 0",
+		"go wtime",
+		"go wtime 1.5",
+		"go movestogo 0",
+		"go movetime",
+		"go nodes",
+		"go nodes 0",
+		"go mate x",
+		"go searchmoves",
+		"go searchmoves depth 3",
+		"go searchmoves e2e4 e2e5",
+		"go sideways",
+		"ponderhit",
+		"setoption",
+		"setoption name",
+		"setoption name Hash",
+		"setoption name Hash value",
+		"setoption name Hash value 0",
+		"setoption name Hash value -3",
+		"setoption name Hash value 1000000",
+		"setoption name UCI_AnalyseMode value maybe",
+		"setoption name TriangularPV value 1",
+		"setoption name Unknown value 3",
+	} {
+		uci := NewUCI()
+		fen := uci.Engine.Position.String()
+		if err := uci.Execute(line); err == nil {
+			t.Errorf("#%d %s: expected error", i, line)
+		}
+		if len(uci.ready) != 0 {
+			t.Errorf("#%d %s: search started", i, line)
+			uci.Execute("stop")
+		}
+		if actual := uci.Engine.Position.String(); actual != fen {
+			t.Errorf("#%d %s: position changed to %s", i, line, actual)
+		}
+	}
+}
+
+func TestRacingKingsSetoption(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+
+	uci := NewUCI()
+	if err := uci.Execute("setoption name Rook Value value 450"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if engine.RK_PIECE_VALUES[engine.Rook] != 450 {
+		t.Errorf("expected rook value 450, got %d", engine.RK_PIECE_VALUES[engine.Rook])
+	}
+	for i, line := range []string{
+		"setoption name King Value value 100",
+		"setoption name Elephant Value value 100",
+		"setoption name Rook Value value 1001",
+		"setoption name Rook Value value",
+		"setoption name King Advance Value value x",
+	} {
+		if err := uci.Execute(line); err == nil {
+			t.Errorf("#%d %s: expected error", i, line)
+		}
+	}
+}
+
+func TestPositionReportsIllegalMoveIndex(t *testing.T) {
+	defer saveGlobals()()
+
+	data := []struct {
+		variant int
+		line    string
+		err     string
+	}{
+		{engine.VARIANT_Standard, "position startpos moves e2e4 f7f6 d1h5 e8f7", "move 4: e8f7"},
+		{engine.VARIANT_Standard, "position fen 4k3/4r3/8/8/8/8/4N3/4K3 w - - moves e2c3", "move 1: e2c3"},
+		// Giving check is illegal in Racing Kings.
+		{engine.VARIANT_Racing_Kings, "position startpos moves h2h3 a2a3 h3g4 a3a4 e2c3", "move 5: e2c3"},
+	}
+
+	for i, d := range data {
+		engine.Variant = d.variant
+		uci := NewUCI()
+		fen := uci.Engine.Position.String()
+		err := uci.Execute(d.line)
+		if err == nil || !strings.HasPrefix(err.Error(), d.err) {
+			t.Errorf("#%d %s: expected error %q, got %v", i, d.line, d.err, err)
+		}
+		if actual := uci.Engine.Position.String(); actual != fen {
+			t.Errorf("#%d %s: position changed to %s", i, d.line, actual)
+		}
+	}
+}
+
+func TestChess960Castling(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Standard
+
+	uci := NewUCI()
+	if err := uci.Execute("setoption name UCI_Chess960 value true"); err != nil {
+		t.Fatal(err)
+	}
+	if !engine.Chess960 {
+		t.Errorf("expected Chess960 to be enabled")
+	}
+
+	// The king on f1 castles by capturing its own rook on g1.
+	if err := uci.Execute("position fen 4k3/8/8/8/8/8/8/5KR1 w G - 0 1 moves f1g1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "4k3/8/8/8/8/8/8/5RK1 b - - 1 1"
+	if actual := uci.Engine.Position.String(); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	if m := uci.Engine.Position.LastMove(); m.UCI() != "f1g1" {
+		t.Errorf("expected castling f1g1, got %s", m.UCI())
+	}
+
+	if err := uci.Execute("setoption name UCI_Chess960 value false"); err != nil {
+		t.Fatal(err)
+	}
+	if engine.Chess960 {
+		t.Errorf("expected Chess960 to be disabled")
+	}
+}
+
+// Tests that the search limits of go are honored.
+func TestGoLimits(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+	bestmove, restore := captureBestMove(t)
+	defer restore()
+
+	data := []struct {
+		line     string
+		expected string // regexp matching the bestmove line
+	}{
+		{"go nodes 5000", "^bestmove [a-h][1-8][a-h][1-8] "},
+		{"go mate 1", "^bestmove [a-h][1-8][a-h][1-8] "},
+		{"go depth 4 searchmoves g2g5", "^bestmove g2g5 "},
+		{"go searchmoves e2d4 g2g5 depth 4", "^bestmove (e2d4|g2g5) "},
+		{"go searchmoves e2f4 g2g5 infinite nodes 5000", "^bestmove (e2f4|g2g5) "},
+	}
+
+	for _, d := range data {
+		uci := NewUCI()
+		for _, line := range []string{"position startpos", d.line} {
+			if err := uci.Execute(line); err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+		}
+
+		select {
+		case line := <-bestmove:
+			if !regexp.MustCompile(d.expected).MatchString(line) {
+				t.Errorf("%s: expected %s, got %q", d.line, d.expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no bestmove", d.line)
+		}
+		// Waits for the search to finish before reading the stats.
+		uci.Execute("isready")
+		// The limit is checked in the main search, allow a few quiescence nodes.
+		if n := uci.Engine.Stats.Nodes; strings.Contains(d.line, "nodes") && n > 5100 {
+			t.Errorf("%s: searched %d nodes", d.line, n)
+		}
+	}
+}
+
+// fuzzTokens are the tokens used to build random command lines.
+var fuzzTokens = []string{
+	"uci", "isready", "ucinewgame", "position", "go", "setoption", "ponderhit", "stop",
+	"startpos", "fen", "moves", "name", "value",
+	"ponder", "infinite", "wtime", "btime", "winc", "binc", "movestogo", "movetime", "depth",
+	"nodes", "mate", "searchmoves",
+	"Hash", "Clear", "UCI_AnalyseMode", "Ponder", "TriangularPV", "Knight", "Rook", "King", "Advance", "Value",
+	"e2e4", "e7e5", "g1f3", "h2h3", "a7a8q", "e1g1", "e2", "z9z9", "g8f6",
+	"8/8/8/8/8/8/krbnNBRK/qrbnNBRQ", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR",
+	"4k3/8/8/8/8/8/8/4K3", "8/8/8", "w", "b", "-", "KQkq", "e3",
+	"-1", "0", "1", "2", "3", "10", "30", "100", "1000", "abc", "true", "false",
+	"999999999999999999999", "", "\t",
+}
+
+// Tests that Execute never panics or blocks on random command lines.
+func TestExecuteRandomCommands(t *testing.T) {
+	defer saveGlobals()()
+	defer silence(t)()
+
+	r := rand.New(rand.NewSource(5))
+	for _, variant := range []int{engine.VARIANT_Standard, engine.VARIANT_Racing_Kings} {
+		engine.Variant = variant
+		uci := NewUCI()
+		for i := 0; i < 1000; i++ {
+			var tokens []string
+			if r.Intn(4) != 0 {
+				// Most lines start with a command.
+				tokens = append(tokens, fuzzTokens[r.Intn(8)])
+			}
+			for n := r.Intn(10); n >= 0; n-- {
+				tokens = append(tokens, fuzzTokens[r.Intn(len(fuzzTokens))])
+			}
+			if r.Intn(4) == 0 {
+				// Mix in a few random bytes.
+				b := make([]byte, r.Intn(8))
+				r.Read(b)
+				tokens = append(tokens, string(b))
+			}
+
+			line := strings.Join(tokens, " ")
+			func() {
+				defer func() {
+					if e := recover(); e != nil {
+						t.Fatalf("%q panicked: %v", line, e)
+					}
+				}()
+				uci.Execute(line)
+				// Stop any search started by the command.
+				uci.Execute("stop")
+			}()
+		}
+	}
+}
+
+func TestEvalCommand(t *testing.T) {
+	defer saveGlobals()()
+	engine.Variant = engine.VARIANT_Racing_Kings
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	uci := NewUCI()
+	for _, line := range []string{"position startpos moves h2h3", "eval"} {
+		if err := uci.Execute(line); err != nil {
+			t.Errorf("%s: unexpected error %v", line, err)
+		}
+	}
+	os.Stdout = stdout
+	w.Close()
+
+	out, _ := ioutil.ReadAll(r)
+	if !regexp.MustCompile(`(?m)^king advance +500 +250 +250$`).Match(out) {
+		t.Errorf("expected king advance of 500 and 250 in output:\n%s", out)
+	}
+	if !regexp.MustCompile(`(?m)^score 250 `).Match(out) {
+		t.Errorf("expected score 250 in output:\n%s", out)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// xboardScript runs the xboard commands in script and returns the replies.
+func xboardScript(t *testing.T, script ...string) string {
+	out := &bytes.Buffer{}
+	in := bufio.NewScanner(strings.NewReader(strings.Join(script[1:], "\n")))
+	if err := runXBoard(script[0], in, out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return out.String()
+}
+
+func TestIsXBoardCommand(t *testing.T) {
+	for _, line := range []string{"xboard", "protover 2", " xboard "} {
+		if !isXBoardCommand(line) {
+			t.Errorf("expected %q to select xboard", line)
+		}
+	}
+	for _, line := range []string{"uci", "", "isready", "xboards"} {
+		if isXBoardCommand(line) {
+			t.Errorf("expected %q to select uci", line)
+		}
+	}
+}
+
+func TestXBoardFeatures(t *testing.T) {
+	defer saveGlobals()()
+
+	out := xboardScript(t, "xboard", "protover 2", "ping 7", "quit")
+	for _, expected := range []string{
+		"feature done=0\n",
+		` variants="normal,racingkings"`,
+		" usermove=1",
+		" ping=1",
+		"feature done=1\n",
+		"pong 7\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+// Tests that after ? the move is sent before the reply to ping.
+func TestXBoardMoveNowPing(t *testing.T) {
+	defer saveGlobals()()
+
+	out := xboardScript(t, "xboard", "protover 2", "new", "variant racingkings", "st 30", "go", "?", "ping 3", "quit")
+	move := regexp.MustCompile(`(?m)^move \S+$`).FindStringIndex(out)
+	pong := strings.Index(out, "pong 3\n")
+	if move == nil || pong < 0 || move[0] > pong {
+		t.Errorf("expected move before pong in output:\n%s", out)
+	}
+}
+
+func TestXBoardRacingKingsGame(t *testing.T) {
+	defer saveGlobals()()
+
+	out := xboardScript(t, "xboard", "protover 2", "new", "variant racingkings", "sd 2", "post", "usermove h2h3")
+	if engine.Variant != engine.VARIANT_Racing_Kings {
+		t.Errorf("expected Racing Kings")
+	}
+
+	// The engine plays Black and answers with a legal move.
+	m := regexp.MustCompile(`(?m)^move (\S+)$`).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("expected a move in output:\n%s", out)
+	}
+	pos, _ := engine.PositionFromFEN(engine.START_FENS[engine.VARIANT_Racing_Kings])
+	pos.DoMove(uciMove(t, pos, "h2h3"))
+	if _, err := pos.UCIToMove(m[1]); err != nil {
+		t.Errorf("invalid reply %s: %v", m[1], err)
+	}
+
+	// post shows the thinking.
+	if !regexp.MustCompile(`(?m)^2 -?\d+ \d+ \d+ \S+`).MatchString(out) {
+		t.Errorf("expected thinking for depth 2 in output:\n%s", out)
+	}
+}
+
+// uciMove parses the legal move s.
+func uciMove(t *testing.T, pos *engine.Position, s string) engine.Move {
+	m, err := pos.UCIToMove(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestXBoardGameOver(t *testing.T) {
+	defer saveGlobals()()
+
+	// Black wins by moving the king to the 8th rank.
+	out := xboardScript(t, "xboard", "new", "variant racingkings", "setboard 8/1k6/8/8/8/8/8/6K1 b - - 0 1", "sd 2", "go")
+	if !strings.Contains(out, "\n0-1 {king reached the 8th rank}\n") {
+		t.Errorf("expected the result in output:\n%s", out)
+	}
+	if !regexp.MustCompile(`(?m)^move b7[abc]8$`).MatchString(out) {
+		t.Errorf("expected a winning move in output:\n%s", out)
+	}
+}
+
+func TestXBoardErrors(t *testing.T) {
+	defer saveGlobals()()
+
+	out := xboardScript(t, "xboard", "new", "variant racingkings", "force",
+		"usermove e2e4", "variant crazyhouse", "sd x", "level 40 5", "foo", "undo")
+	for _, expected := range []string{
+		"Illegal move: e2e4\n",
+		"Error (unsupported variant crazyhouse): variant crazyhouse\n",
+		"Error (invalid value \"x\" for sd): sd x\n",
+		"Error (expected MPS BASE INC): level 40 5\n",
+		"Error (unknown command): foo\n",
+		"Error (no move to undo): undo\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	if regexp.MustCompile(`(?m)^move `).MatchString(out) {
+		t.Errorf("expected no move in force mode:\n%s", out)
+	}
+}
+
+func TestXBoardForceUndoSetboard(t *testing.T) {
+	defer saveGlobals()()
+
+	out := &bytes.Buffer{}
+	xb := NewXBoard(out)
+	for _, line := range []string{"new", "force", "usermove e2e4", "usermove e7e5", "usermove Nf3"} {
+		if err := xb.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	expected := "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"
+	if actual := xb.Engine.Position.String(); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+
+	xb.Execute("remove")
+	expected = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+	if actual := xb.Engine.Position.String(); actual != expected {
+		t.Errorf("expected %s after remove, got %s", expected, actual)
+	}
+
+	fen := "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1"
+	xb.Execute("variant racingkings")
+	xb.Execute("setboard " + fen)
+	if actual := xb.Engine.Position.String(); actual != fen {
+		t.Errorf("expected %s after setboard, got %s", fen, actual)
+	}
+
+	// Only the moves played after setboard can be undone.
+	xb.Execute("setboard 8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 20")
+	if err := xb.Execute("undo"); err == nil {
+		t.Errorf("expected error for undo after setboard")
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestXBoardTimeControl(t *testing.T) {
+	defer saveGlobals()()
+
+	xb := NewXBoard(&bytes.Buffer{})
+	for _, line := range []string{"new", "variant racingkings", "level 40 0:30 2", "time 1500", "otim 2000"} {
+		if err := xb.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	// White is to move so the engine's clock is White's.
+	tc := xb.newTimeControl()
+	if tc.WTime != 15*time.Second || tc.BTime != 20*time.Second {
+		t.Errorf("expected clocks 15s and 20s, got %v and %v", tc.WTime, tc.BTime)
+	}
+	if tc.WInc != 2*time.Second || tc.BInc != 2*time.Second {
+		t.Errorf("expected increments of 2s, got %v and %v", tc.WInc, tc.BInc)
+	}
+	if tc.MovesToGo != 40 {
+		t.Errorf("expected 40 moves to go, got %d", tc.MovesToGo)
+	}
+
+	xb.Execute("st 0.5")
+	xb.Execute("sd 7")
+	tc = xb.newTimeControl()
+	if tc.WTime != 500*time.Millisecond || tc.MovesToGo != 1 || tc.Depth != 7 {
+		t.Errorf("expected 0.5s for one move at depth 7, got %v for %d moves at depth %d", tc.WTime, tc.MovesToGo, tc.Depth)
+	}
+}
+
+func TestXBoardAnalyze(t *testing.T) {
+	defer saveGlobals()()
+
+	out := &bytes.Buffer{}
+	xb := NewXBoard(out)
+	xb.Execute("new")
+	xb.Execute("variant racingkings")
+	xb.Execute("analyze")
+	time.Sleep(100 * time.Millisecond)
+	// The analysis restarts from the new position.
+	xb.Execute("usermove h2h3")
+	time.Sleep(100 * time.Millisecond)
+	xb.Execute("exit")
+	xb.Execute("quit")
+
+	if !regexp.MustCompile(`(?m)^\d+ -?\d+ \d+ \d+ \S+`).MatchString(out.String()) {
+		t.Errorf("expected analysis in output:\n%s", out)
+	}
+	if regexp.MustCompile(`(?m)^move `).MatchString(out.String()) {
+		t.Errorf("expected no move while analyzing:\n%s", out)
+	}
+	if xb.Engine.Position.SideToMove != engine.Black {
+		t.Errorf("expected Black to move")
+	}
+}
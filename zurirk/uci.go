@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,8 +16,18 @@ import (
 
 var (
 	errQuit = fmt.Errorf("quit")
+
+	// goArguments are the arguments of the go command.
+	goArguments = map[string]bool{
+		"searchmoves": true, "ponder": true, "wtime": true, "btime": true,
+		"winc": true, "binc": true, "movestogo": true, "depth": true,
+		"nodes": true, "mate": true, "movetime": true, "infinite": true,
+	}
 )
 
+// maxNodes is the largest accepted node limit.
+const maxNodes = 1<<31 - 1
+
 // uciLogger outputs search in uci format.
 type uciLogger struct {
 	start time.Time
@@ -57,6 +66,7 @@ func (ul *uciLogger) PrintPV(stats engine.Stats, score int32, pv []engine.Move)
 	nps := stats.Nodes * uint64(time.Second) / elapsed
 	millis := elapsed / uint64(time.Millisecond)
 	fmt.Fprintf(ul.buf, "nodes %d time %d nps %d ", stats.Nodes, millis, nps)
+	fmt.Fprintf(ul.buf, "hashfull %d ", engine.GlobalHashTable.Hashfull())
 
 	// Write principal variation.
 	fmt.Fprintf(ul.buf, "pv")
@@ -95,8 +105,14 @@ type UCI struct {
 	ready chan struct{}
 	// buffer of 1, if filled then the engine is pondering
 	ponder chan struct{}
+	// true if the channel ponder was filled by go ponder
+	pondering bool
 	// predicted position hash after 2 moves.
 	predicted uint64
+	// true if the debug commands are enabled
+	debug bool
+	// clock used by the time controls, replaced in tests
+	clock engine.Clock
 }
 
 func NewUCI() *UCI {
@@ -106,24 +122,18 @@ func NewUCI() *UCI {
 		timeControl: nil,
 		ready:       make(chan struct{}, 1),
 		ponder:      make(chan struct{}, 1),
+		clock:       engine.WallClock,
 	}
 }
 
 var reCmd = regexp.MustCompile(`^[[:word:]]+\b`)
 
-var reF = regexp.MustCompile("^f ")
-
 func (uci *UCI) Execute(line string) error {
 	line = strings.TrimSpace(line)
 	if line == "" {
 		return nil
 	}
 
-	if engine.IsTest() {
-		// Convenience command f for set from fen.
-		line=reF.ReplaceAllString(line,"position fen ")
-	}
-
 	cmd := reCmd.FindString(line)
 	if cmd == "" {
 		return fmt.Errorf("invalid command line")
@@ -147,46 +157,12 @@ func (uci *UCI) Execute(line string) error {
 	uci.ready <- struct{}{}
 	<-uci.ready
 
-	///////////////////////////////////////////////////
-	// NEW
-	// test commands
-	if(engine.IsTest()) {
-		switch cmd {
-		case "uu":
-			engine.SetUseUnicodeSymbols(true)
-			return nil
-		case "uc":
-			engine.SetUseUnicodeSymbols(false)
-			return nil
-		case "s":
-			uci.SetVariant(engine.VARIANT_Standard)
-			uci.PrintBoard()
-			return nil
-		case "r":
-			uci.SetVariant(engine.VARIANT_Racing_Kings)
-			uci.PrintBoard()
-			return nil
-		case "p":
-			uci.PrintBoard()
-			return nil
-		case "m":
-			uci.MakeSanMove(line)
-			return nil
-		case "d":
-			uci.UndoMove(line)
-			return nil
-		case "l":
-			uci.Engine.Position.PrintLegalMoves()
-			return nil
-		case "vs":
-			engine.PrintPieceValues()
-			return nil
-		case "x":
-			return errQuit
+	// Debug commands are only available if enabled.
+	if uci.debug {
+		if dc, ok := debugCommands[cmd]; ok {
+			return dc.run(uci, line)
 		}
 	}
-	// END NEW
-	///////////////////////////////////////////////////
 
 	// These commands expect engine to be ready.
 	switch cmd {
@@ -198,6 +174,8 @@ func (uci *UCI) Execute(line string) error {
 		return uci.go_(line)
 	case "setoption":
 		return uci.setoption(line)
+	case "eval":
+		return uci.eval(line)
 	default:
 		return fmt.Errorf("unhandled command %s", cmd)
 	}
@@ -205,43 +183,6 @@ func (uci *UCI) Execute(line string) error {
 
 ///////////////////////////////////////////////////
 // NEW
-var reMakeSanMove = regexp.MustCompile(`^m\s+([^\s]+)$`)
-
-func (uci *UCI) PrintBoard() error {
-	// Print the board.
-	uci.Engine.PrintBoard()
-	return nil
-}
-
-func (uci *UCI) MakeSanMove(line string) error {
-	option := reMakeSanMove.FindStringSubmatch(line)
-	if option == nil {
-		res:=fmt.Errorf("invalid make san move arguments")
-		fmt.Println(res)
-		return res
-	}
-	move, err := uci.Engine.Position.SANToMove(option[1])
-	if err != nil {
-		res:=fmt.Errorf("invalid move")
-		fmt.Println(res)
-		return res
-	}
-	uci.Engine.DoMove(move)
-	uci.PrintBoard()
-	return nil
-}
-
-func (uci *UCI) UndoMove(line string) error {
-	if uci.Engine.Position.GetNoStates()<2 {
-		res:=fmt.Errorf("no move to delete")
-		fmt.Println(res)
-		return res
-	}
-	uci.Engine.UndoMove()
-	uci.PrintBoard()
-	return nil
-}
-
 func (uci *UCI) SetVariant(setVariant int) error {
 	uci.Engine.SetVariant(setVariant)
 	return nil
@@ -255,6 +196,11 @@ func (uci *UCI) uci(line string) error {
 	fmt.Printf("option name UCI_AnalyseMode type check default false\n")
 	fmt.Printf("option name Hash type spin default %v min 1 max 65536\n", engine.DefaultHashTableSizeMB)
 	fmt.Printf("option name Ponder type check default true\n")
+	fmt.Printf("option name TriangularPV type check default false\n")
+	fmt.Printf("option name Debug type check default %v\n", uci.debug)
+	if engine.Variant == engine.VARIANT_Standard {
+		fmt.Printf("option name UCI_Chess960 type check default %v\n", engine.Chess960)
+	}
 	if engine.Variant == engine.VARIANT_Racing_Kings {
 		for piece:=engine.Knight ; piece<engine.King ; piece++ {
 			fmt.Printf("option name %s Value type spin default %d min 0 max 1000\n", 
@@ -280,100 +226,129 @@ func (uci *UCI) ucinewgame(line string) error {
 }
 
 func (uci *UCI) position(line string) error {
-	args := strings.Fields(line)[1:]
-	if len(args) == 0 {
+	args := newUCIArgs(line, "position")
+	if args.done() {
 		return fmt.Errorf("expected argument for 'position'")
 	}
 
 	var pos *engine.Position
-
-	i := 0
 	var err error
-	switch args[i] {
+	switch arg := args.next(); arg {
 	case "startpos":
-		uci.SetVariant(engine.VARIANT_CURRENT)
-		i++
+		pos, err = engine.PositionFromFEN(engine.START_FENS[engine.Variant])
 	case "fen":
-		pos, err = engine.PositionFromFEN(strings.Join(args[1:7], " "))
-		if err != nil {
-			return err
+		// The halfmove clock and the fullmove counter are optional.
+		fields := args.until("moves")
+		if len(fields) < 4 || len(fields) > 6 {
+			return fmt.Errorf("expected 4 to 6 fen fields, got %d", len(fields))
 		}
-		uci.Engine.SetPosition(pos)
-		i += 7
+		fen := append([]string{}, fields...)
+		fen = append(fen, []string{"0", "1"}[len(fen)-4:]...)
+		pos, err = engine.PositionFromFEN(strings.Join(fen, " "))
 	default:
-		err = fmt.Errorf("unknown position command: %s", args[0])
+		return fmt.Errorf("unknown position command: %s", arg)
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkKings(pos); err != nil {
 		return err
 	}
 
-	if i < len(args) {
-		if args[i] != "moves" {
-			return fmt.Errorf("expected 'moves', got '%s'", args[1])
+	if !args.done() {
+		if arg := args.next(); arg != "moves" {
+			return fmt.Errorf("expected 'moves', got '%s'", arg)
 		}
-		for _, m := range args[i+1:] {
-			if move, err := uci.Engine.Position.UCIToMove(m); err != nil {
-				return err
-			} else {
-				uci.Engine.DoMove(move)
+		// Reject the whole list if any move is illegal.
+		for i := 1; !args.done(); i++ {
+			move, err := pos.UCIToMove(args.next())
+			if err != nil {
+				return fmt.Errorf("move %d: %v", i, err)
 			}
+			pos.DoMove(move)
 		}
 	}
 
+	// Change the position only if the whole command is valid.
+	uci.Engine.SetPosition(pos)
+	return nil
+}
+
+// checkKings returns an error if either side doesn't have exactly one king.
+func checkKings(pos *engine.Position) error {
+	for _, col := range []engine.Color{engine.White, engine.Black} {
+		if n := pos.ByPiece(col, engine.King).Count(); n != 1 {
+			return fmt.Errorf("expected one %v king, got %d", col, n)
+		}
+	}
 	return nil
 }
 
 func (uci *UCI) go_(line string) error {
-	// TODO: Handle panic for `go depth`
 	predicted := uci.predicted == uci.Engine.Position.Zobrist()
-	uci.timeControl = engine.NewTimeControl(uci.Engine.Position, predicted)
-	uci.timeControl.MovesToGo = 30 // in case there is not time refresh
+	// MovesToGo is estimated from the position in case there is no time refresh.
+	tc := engine.NewTimeControl(uci.Engine.Position, predicted)
 	ponder := false
 
-	args := strings.Fields(line)[1:]
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
+	// The time control is changed only if all arguments are valid.
+	args := newUCIArgs(line, "go")
+	for !args.done() {
+		var err error
+		switch arg := args.next(); arg {
 		case "ponder":
 			ponder = true
 		case "infinite":
-			uci.timeControl = engine.NewTimeControl(uci.Engine.Position, false)
+			// Keep the limits which are not about time.
+			inf := engine.NewTimeControl(uci.Engine.Position, false)
+			inf.Depth, inf.Nodes, inf.SearchMoves = tc.Depth, tc.Nodes, tc.SearchMoves
+			tc = inf
 		case "wtime":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.WTime = time.Duration(t) * time.Millisecond
+			tc.WTime, err = args.millis(arg)
 		case "winc":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.WInc = time.Duration(t) * time.Millisecond
+			tc.WInc, err = args.millis(arg)
 		case "btime":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.BTime = time.Duration(t) * time.Millisecond
+			tc.BTime, err = args.millis(arg)
 		case "binc":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.BInc = time.Duration(t) * time.Millisecond
+			tc.BInc, err = args.millis(arg)
 		case "movestogo":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.MovesToGo = t
+			tc.MovesToGo, err = args.int(arg, 1, 1000)
 		case "movetime":
-			i++
-			t, _ := strconv.Atoi(args[i])
-			uci.timeControl.WTime = time.Duration(t) * time.Millisecond
-			uci.timeControl.WInc = 0
-			uci.timeControl.BTime = time.Duration(t) * time.Millisecond
-			uci.timeControl.BInc = 0
-			uci.timeControl.MovesToGo = 1
+			var t time.Duration
+			if t, err = args.millis(arg); err == nil {
+				tc.WTime, tc.WInc = t, 0
+				tc.BTime, tc.BInc = t, 0
+				tc.MovesToGo = 1
+			}
 		case "depth":
-			i++
-			d, _ := strconv.Atoi(args[i])
-			uci.timeControl.Depth = int32(d)
+			var d int
+			d, err = args.int(arg, 1, 64)
+			tc.Depth = int32(d)
+		case "nodes":
+			var n int
+			n, err = args.int(arg, 1, maxNodes)
+			tc.Nodes = uint64(n)
+		case "mate":
+			// A mate in n moves is found searching 2n-1 plies.
+			var n int
+			n, err = args.int(arg, 1, 32)
+			tc.Depth = int32(2*n - 1)
+		case "searchmoves":
+			tc.SearchMoves, err = uci.searchMoves(args)
+		default:
+			err = fmt.Errorf("unhandled go argument %s", arg)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
+	tc.Clock = uci.clock
+	uci.timeControl = tc
 	if ponder {
 		// Ponder was requested, so fill the channel.
 		// Next write to uci.ponder will block.
 		uci.ponder <- struct{}{}
+		uci.pondering = true
 	}
 
 	uci.timeControl.Start(ponder)
@@ -382,7 +357,35 @@ func (uci *UCI) go_(line string) error {
 	return nil
 }
 
+// searchMoves parses the moves following searchmoves
+// up to the next go argument.
+func (uci *UCI) searchMoves(args *uciArgs) ([]engine.Move, error) {
+	var moves []engine.Move
+	for !args.done() && !goArguments[args.peek()] {
+		move, err := uci.Engine.Position.UCIToMove(args.next())
+		if err != nil {
+			return nil, fmt.Errorf("searchmoves: %v", err)
+		}
+		moves = append(moves, move)
+	}
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("missing moves for searchmoves")
+	}
+	return moves, nil
+}
+
+// eval prints the evaluation of the current position broken down by term.
+// It is not part of UCI, but handy to sanity check the evaluation.
+func (uci *UCI) eval(line string) error {
+	fmt.Print(engine.EvaluateTrace(uci.Engine.Position))
+	return nil
+}
+
 func (uci *UCI) ponderhit(line string) error {
+	if !uci.pondering {
+		return fmt.Errorf("ponderhit while not pondering")
+	}
+	uci.pondering = false
 	uci.timeControl.PonderHit()
 	<-uci.ponder
 	return nil
@@ -393,10 +396,11 @@ func (uci *UCI) stop(line string) error {
 	if uci.timeControl != nil {
 		uci.timeControl.Stop()
 	}
-	// No longer pondering.
-	select {
-	case <-uci.ponder:
-	default:
+	// No longer pondering. On a ponder miss the hash table
+	// is kept because the next search is for a sibling position.
+	if uci.pondering {
+		uci.pondering = false
+		<-uci.ponder
 	}
 	// Waits until the engine becomes ready.
 	uci.ready <- struct{}{}
@@ -448,39 +452,41 @@ func (uci *UCI) setoption(line string) error {
 	if option == nil {
 		return fmt.Errorf("invalid setoption arguments")
 	}
+	name, value := option[1], option[3]
 
 	// Handle buttons which don't have a value.
-	if len(option) < 1 {
-		return fmt.Errorf("missing setoption name")
-	}
-	switch option[1] {
+	switch name {
 	case "Clear Hash":
 		engine.GlobalHashTable.Clear()
 		return nil
 	}
 
 	// Handle remaining values.
-	if len(option) < 3 {
-		return fmt.Errorf("missing setoption value")
+	if option[2] == "" {
+		return fmt.Errorf("missing value for option %s", name)
 	}
 
 	///////////////////////////////////////////////////
 	// NEW
 	if engine.Variant == engine.VARIANT_Racing_Kings {
-		setPieceValue := reRkSetPieceValue.FindStringSubmatch(option[1])
+		setPieceValue := reRkSetPieceValue.FindStringSubmatch(name)
 		if setPieceValue != nil {
-			pieceValue , err := strconv.ParseInt(option[3], 10, 32)
+			fig := engine.FigureNameToFigure(setPieceValue[1])
+			if fig < engine.Knight || fig >= engine.King {
+				return fmt.Errorf("unhandled option %s", name)
+			}
+			pieceValue, err := parseSpin(name, value, 0, 1000)
 			if err != nil {
-				return fmt.Errorf("wrong piece value")
+				return err
 			}
-			engine.RK_PIECE_VALUES[engine.FigureNameToFigure(setPieceValue[1])]=int32(pieceValue)
+			engine.RK_PIECE_VALUES[fig] = int32(pieceValue)
 			return nil
 		}
-		switch option[1] {
-		case "King Advance Value" :
-			kingAdvanceValue , err := strconv.ParseInt(option[3], 10, 32)
+		switch name {
+		case "King Advance Value":
+			kingAdvanceValue, err := parseSpin(name, value, 0, 1000)
 			if err != nil {
-				return fmt.Errorf("wrong king advance value")
+				return err
 			}
 			engine.KING_ADVANCE_VALUE = int32(kingAdvanceValue)
 			return nil
@@ -488,22 +494,64 @@ func (uci *UCI) setoption(line string) error {
 	}
 	///////////////////////////////////////////////////
 
-	switch option[1] {
+	switch name {
 	case "UCI_AnalyseMode":
-		if mode, err := strconv.ParseBool(option[3]); err != nil {
+		if mode, err := parseCheck(name, value); err != nil {
 			return err
 		} else {
 			uci.Engine.Options.AnalyseMode = mode
 		}
 		return nil
 	case "Hash":
-		if hashSizeMB, err := strconv.ParseInt(option[3], 10, 64); err != nil {
+		if hashSizeMB, err := parseSpin(name, value, 1, 65536); err != nil {
+			return err
+		} else {
+			engine.GlobalHashTable = engine.NewHashTable(hashSizeMB)
+		}
+		return nil
+	case "TriangularPV":
+		if triangular, err := parseCheck(name, value); err != nil {
+			return err
+		} else {
+			uci.Engine.Options.TriangularPV = triangular
+		}
+		return nil
+	case "UCI_Chess960":
+		if chess960, err := parseCheck(name, value); err != nil {
 			return err
 		} else {
-			engine.GlobalHashTable = engine.NewHashTable(int(hashSizeMB))
+			engine.Chess960 = chess960
 		}
 		return nil
+	case "Debug":
+		if debug, err := parseCheck(name, value); err != nil {
+			return err
+		} else {
+			uci.debug = debug
+		}
+		return nil
+	case "Ponder":
+		// Pondering is controlled by go ponder.
+		_, err := parseCheck(name, value)
+		return err
 	default:
-		return fmt.Errorf("unhandled option %s", option[1])
+		return fmt.Errorf("unhandled option %s", name)
+	}
+}
+
+// parseSpin parses the value of a spin option between min and max.
+func parseSpin(name, value string, min, max int) (int, error) {
+	args := &uciArgs{args: []string{value}}
+	return args.int(name, min, max)
+}
+
+// parseCheck parses the value of a check option.
+func parseCheck(name, value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
 	}
+	return false, fmt.Errorf("invalid value %q for %s, expected true or false", value, name)
 }
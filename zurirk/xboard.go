@@ -0,0 +1,459 @@
+// xboard.go implements the Chess Engine Communication Protocol (CECP) used by
+// XBoard and WinBoard, described at https://www.gnu.org/software/xboard/engine-intf.html.
+//
+// The protocol is selected when the first command is xboard or protover.
+// Moves are written in coordinate notation (e2e4, e7e8q); moves received
+// from the GUI are also accepted in SAN.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goracingkingsengine/zurirk/engine"
+)
+
+// xboardFeatures are the features announced in reply to protover.
+var xboardFeatures = []string{
+	`ping=1`, `setboard=1`, `usermove=1`, `time=1`, `draw=0`, `sigint=0`, `sigterm=0`,
+	`reuse=1`, `analyze=1`, `colors=0`, `memory=1`, `myname="zurirk"`,
+	`variants="normal,racingkings"`,
+}
+
+// xboardVariants maps the variant names used by the protocol to variants.
+var xboardVariants = map[string]int{
+	"normal":      engine.VARIANT_Standard,
+	"racingkings": engine.VARIANT_Racing_Kings,
+}
+
+// isXBoardCommand returns true if line selects the xboard protocol.
+func isXBoardCommand(line string) bool {
+	cmd := strings.Fields(line)
+	return len(cmd) > 0 && (cmd[0] == "xboard" || cmd[0] == "protover")
+}
+
+// xboardLogger outputs the thinking in xboard format.
+type xboardLogger struct {
+	xb    *XBoard
+	start time.Time
+}
+
+func (xl *xboardLogger) BeginSearch() {
+	xl.start = time.Now()
+}
+
+func (xl *xboardLogger) EndSearch() {
+}
+
+func (xl *xboardLogger) PrintPV(stats engine.Stats, score int32, pv []engine.Move) {
+	if !xl.xb.isPosting() {
+		return
+	}
+	var moves []string
+	for _, m := range pv {
+		moves = append(moves, m.UCI())
+	}
+	centis := time.Since(xl.start) / (10 * time.Millisecond)
+	xl.xb.printf("%d %d %d %d %s\n", stats.Depth, xboardScore(score), centis, stats.Nodes, strings.Join(moves, " "))
+}
+
+// xboardScore converts score to centipawns, or, for mates,
+// to 100000 plus the number of moves to mate.
+func xboardScore(score int32) int32 {
+	if score > engine.KnownWinScore {
+		return 100000 + (engine.MateScore-score+1)/2
+	}
+	if score < engine.KnownLossScore {
+		return -100000 - (score-engine.MatedScore)/2
+	}
+	return score
+}
+
+// XBoard implements the xboard protocol.
+type XBoard struct {
+	Engine      *engine.Engine
+	timeControl *engine.TimeControl
+
+	// buffer of 1, if filled then the engine is searching
+	ready chan struct{}
+
+	// lock protects out and the fields read by the search goroutine.
+	lock      sync.Mutex
+	out       io.Writer
+	post      bool // true to show the thinking output
+	cancelled bool // true if the move found must not be played
+
+	color     engine.Color // color played by the engine, NoColor in force mode
+	analyzing bool         // true in analyze mode
+
+	// Time control set by level, st, sd, time and otim.
+	movesPerSession int           // moves in each time control, 0 if the base time is for the whole game
+	base, inc       time.Duration // time for each session and increment per move
+	moveTime        time.Duration // exact time for each move, 0 if not set
+	depth           int32         // maximum search depth, 0 if not set
+	time, otim      time.Duration // remaining time on the engine's and opponent's clocks
+}
+
+// NewXBoard returns a new xboard front-end writing the replies to out.
+func NewXBoard(out io.Writer) *XBoard {
+	xb := &XBoard{
+		ready:           make(chan struct{}, 1),
+		out:             out,
+		color:           engine.Black,
+		movesPerSession: 40,
+		base:            5 * time.Minute,
+		time:            5 * time.Minute,
+		otim:            5 * time.Minute,
+	}
+	xb.Engine = engine.NewEngine(nil, &xboardLogger{xb: xb}, engine.Options{})
+	xb.Engine.SetVariant(engine.Variant)
+	return xb
+}
+
+// runXBoard executes first, the command which selected the protocol,
+// and the commands read from in until quit or the end of the input.
+func runXBoard(first string, in *bufio.Scanner, out io.Writer) error {
+	xb := NewXBoard(out)
+	for line := first; ; line = in.Text() {
+		if err := xb.Execute(line); err == errQuit {
+			return nil
+		} else if err != nil {
+			xb.printf("Error (%v): %s\n", err, strings.TrimSpace(line))
+		}
+		if !in.Scan() {
+			break
+		}
+	}
+	xb.wait()
+	return in.Err()
+}
+
+// printf writes a reply to the GUI.
+func (xb *XBoard) printf(format string, args ...interface{}) {
+	xb.lock.Lock()
+	defer xb.lock.Unlock()
+	fmt.Fprintf(xb.out, format, args...)
+}
+
+// isPosting returns true if the thinking output is shown.
+func (xb *XBoard) isPosting() bool {
+	xb.lock.Lock()
+	defer xb.lock.Unlock()
+	return xb.post || xb.analyzing
+}
+
+// Execute executes a single xboard command.
+func (xb *XBoard) Execute(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := args[0]
+	args = args[1:]
+
+	// These commands do not interrupt the search.
+	switch cmd {
+	case "xboard", "accepted", "rejected", "random", "computer", "name", "rating", "ics", "hard", "easy", "draw", "hint", "bk", ".":
+		return nil
+	case "protover":
+		xb.printf("feature done=0\n")
+		xb.printf("feature %s\n", strings.Join(xboardFeatures, " "))
+		xb.printf("feature done=1\n")
+		return nil
+	case "ping":
+		// A move being searched is sent before pong, so the GUI
+		// knows it belongs to the position before the ping.
+		if !xb.analyzing {
+			xb.wait()
+		}
+		xb.printf("pong %s\n", strings.Join(args, " "))
+		return nil
+	case "post", "nopost":
+		xb.lock.Lock()
+		xb.post = cmd == "post"
+		xb.lock.Unlock()
+		return nil
+	case "?":
+		// Move now. The move found so far is played.
+		if xb.timeControl != nil && !xb.analyzing {
+			xb.timeControl.Stop()
+		}
+		return nil
+	case "time", "otim":
+		centis, err := xboardInt(cmd, args, 0, 1<<30)
+		if err == nil && cmd == "time" {
+			xb.time = time.Duration(centis) * 10 * time.Millisecond
+		} else if err == nil {
+			xb.otim = time.Duration(centis) * 10 * time.Millisecond
+		}
+		return err
+	case "quit":
+		xb.stop(true)
+		return errQuit
+	}
+
+	// The remaining commands wait for the search to end. The move
+	// being searched is abandoned, and analysis is resumed afterwards.
+	xb.stop(true)
+	err := xb.execute(cmd, args)
+	if xb.analyzing {
+		xb.think()
+	}
+	return err
+}
+
+// execute runs a command while the engine is not searching.
+func (xb *XBoard) execute(cmd string, args []string) error {
+	switch cmd {
+	case "new":
+		xb.Engine.SetVariant(engine.VARIANT_Standard)
+		engine.GlobalHashTable.Clear()
+		xb.color = engine.Black
+		xb.depth = 0
+		xb.time, xb.otim = xb.base, xb.base
+		return nil
+	case "variant":
+		if len(args) != 1 {
+			return fmt.Errorf("expected a variant")
+		}
+		variant, ok := xboardVariants[args[0]]
+		if !ok {
+			return fmt.Errorf("unsupported variant %s", args[0])
+		}
+		xb.Engine.SetVariant(variant)
+		return nil
+	case "setboard":
+		pos, err := engine.PositionFromFEN(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		if err := checkKings(pos); err != nil {
+			return err
+		}
+		xb.Engine.SetPosition(pos)
+		return nil
+	case "force":
+		xb.color = engine.NoColor
+		return nil
+	case "go":
+		xb.color = xb.Engine.Position.SideToMove
+		xb.think()
+		return nil
+	case "playother":
+		xb.color = xb.Engine.Position.SideToMove.Opposite()
+		return nil
+	case "usermove":
+		return xb.usermove(args)
+	case "undo", "remove":
+		n := 1
+		if cmd == "remove" {
+			n = 2
+		}
+		// Only the moves played since new or setboard can be undone.
+		if len(xb.Engine.Position.History()) < n {
+			return fmt.Errorf("no move to undo")
+		}
+		for i := 0; i < n; i++ {
+			xb.Engine.UndoMove()
+		}
+		return nil
+	case "level":
+		return xb.level(args)
+	case "st":
+		seconds, err := xboardSeconds(cmd, args)
+		if err == nil {
+			xb.moveTime = seconds
+		}
+		return err
+	case "sd":
+		depth, err := xboardInt(cmd, args, 1, 63)
+		if err == nil {
+			xb.depth = int32(depth)
+		}
+		return err
+	case "memory":
+		mb, err := xboardInt(cmd, args, 1, 65536)
+		if err == nil {
+			engine.GlobalHashTable = engine.NewHashTable(mb)
+		}
+		return err
+	case "analyze":
+		xb.analyzing = true
+		xb.color = engine.NoColor
+		return nil
+	case "exit":
+		xb.analyzing = false
+		return nil
+	case "result":
+		xb.color = engine.NoColor
+		return nil
+	default:
+		return fmt.Errorf("unknown command")
+	}
+}
+
+// usermove plays the opponent's move and, if it is the engine's turn, starts thinking.
+func (xb *XBoard) usermove(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a move")
+	}
+	pos := xb.Engine.Position
+	move, err := parseMove(pos, args[0])
+	if err != nil {
+		xb.printf("Illegal move: %s\n", args[0])
+		return nil
+	}
+	xb.Engine.DoMove(move)
+	if !xb.printResult() && pos.SideToMove == xb.color && !xb.analyzing {
+		xb.think()
+	}
+	return nil
+}
+
+// level sets a conventional clock: MPS moves in BASE minutes, or
+// minutes:seconds, with an increment of INC seconds per move.
+func (xb *XBoard) level(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("expected MPS BASE INC")
+	}
+	mps, err := strconv.Atoi(args[0])
+	if err != nil || mps < 0 {
+		return fmt.Errorf("invalid moves per session %s", args[0])
+	}
+	var minutes, seconds int
+	if _, err := fmt.Sscanf(args[1], "%d:%d", &minutes, &seconds); err != nil {
+		if minutes, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid base time %s", args[1])
+		}
+		seconds = 0
+	}
+	inc, err := xboardSeconds("increment", args[2:])
+	if err != nil {
+		return err
+	}
+
+	xb.movesPerSession = mps
+	xb.base = time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	xb.inc = inc
+	xb.time, xb.otim = xb.base, xb.base
+	xb.moveTime = 0
+	return nil
+}
+
+// newTimeControl returns the time control for the next search.
+func (xb *XBoard) newTimeControl() *engine.TimeControl {
+	pos := xb.Engine.Position
+	tc := engine.NewTimeControl(pos, false)
+	if xb.analyzing {
+		return tc
+	}
+	if xb.depth != 0 {
+		tc.Depth = xb.depth
+	}
+	if xb.moveTime != 0 {
+		tc.WTime, tc.WInc = xb.moveTime, 0
+		tc.BTime, tc.BInc = xb.moveTime, 0
+		tc.MovesToGo = 1
+		return tc
+	}
+
+	if pos.SideToMove == engine.White {
+		tc.WTime, tc.BTime = xb.time, xb.otim
+	} else {
+		tc.WTime, tc.BTime = xb.otim, xb.time
+	}
+	tc.WInc, tc.BInc = xb.inc, xb.inc
+	if xb.movesPerSession != 0 {
+		tc.MovesToGo = xb.movesPerSession - pos.Ply/2%xb.movesPerSession
+	}
+	return tc
+}
+
+// think starts searching the current position.
+// Unless analyzing, the move found is played.
+func (xb *XBoard) think() {
+	if !xb.analyzing && xb.printResult() {
+		return
+	}
+	xb.timeControl = xb.newTimeControl()
+	xb.timeControl.Start(false)
+	xb.ready <- struct{}{}
+	go xb.play(xb.analyzing)
+}
+
+// play runs the search.
+// Should run in its own separate goroutine.
+func (xb *XBoard) play(analyzing bool) {
+	moves := xb.Engine.Play(xb.timeControl)
+
+	xb.lock.Lock()
+	cancelled := xb.cancelled
+	xb.lock.Unlock()
+	if !analyzing && !cancelled && len(moves) != 0 {
+		xb.Engine.DoMove(moves[0])
+		xb.printf("move %s\n", moves[0].UCI())
+		xb.printResult()
+	}
+
+	// Marks the engine as ready.
+	<-xb.ready
+}
+
+// stop stops the search and waits for it to end.
+// If cancel is true the move found is not played.
+func (xb *XBoard) stop(cancel bool) {
+	xb.lock.Lock()
+	xb.cancelled = cancel
+	xb.lock.Unlock()
+	if xb.timeControl != nil {
+		xb.timeControl.Stop()
+	}
+	xb.wait()
+	xb.lock.Lock()
+	xb.cancelled = false
+	xb.lock.Unlock()
+}
+
+// wait waits until the engine is not searching.
+func (xb *XBoard) wait() {
+	xb.ready <- struct{}{}
+	<-xb.ready
+}
+
+// printResult prints the result if the game has ended.
+// Returns true if the game has ended.
+func (xb *XBoard) printResult() bool {
+	result := xb.Engine.Position.Result()
+	if result.Outcome == engine.Ongoing {
+		return false
+	}
+	xb.printf("%v\n", result)
+	return true
+}
+
+// xboardInt parses the single argument of cmd as an integer between min and max.
+func xboardInt(cmd string, args []string, min, max int) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected one argument for %s", cmd)
+	}
+	ua := &uciArgs{args: args}
+	return ua.int(cmd, min, max)
+}
+
+// xboardSeconds parses the single argument of cmd as a number of seconds.
+func xboardSeconds(cmd string, args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected one argument for %s", cmd)
+	}
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || seconds < 0 || seconds > 1e6 {
+		return 0, fmt.Errorf("invalid value %q for %s", args[0], cmd)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}